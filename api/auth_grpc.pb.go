@@ -22,11 +22,41 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type AuthServiceClient interface {
+	// Sign up user, based on username/password. Returns registered User.
 	SignUp(ctx context.Context, in *SignUpRequest, opts ...grpc.CallOption) (*User, error)
+	// Sign in user, based on username/password. Returns short-lived JWT
+	// and long-lived refresh token.
 	SignIn(ctx context.Context, in *SignInRequest, opts ...grpc.CallOption) (*SignInResponce, error)
+	// Exchange refresh token for a new JWT/refresh token pair.
+	// Reusing an already exchanged refresh token revokes the whole token family.
+	RefreshToken(ctx context.Context, in *RefreshRequest, opts ...grpc.CallOption) (*SignInResponce, error)
+	// Update user account (username or password).
+	// Also using in Sing up case, to update user id from business-logic DB.
+	// Requires valid token. Only token owner's account can be updated,
+	// unless token owner has admin role.
 	Update(ctx context.Context, in *UpdRequest, opts ...grpc.CallOption) (*User, error)
+	// Delete authorized user and revoke token.
+	// Only token owner's account can be deleted, unless token owner has admin role.
 	Delete(ctx context.Context, in *DelRequest, opts ...grpc.CallOption) (*Response, error)
+	// Revoke passed access token, and the refresh token family when
+	// refresh token is given.
+	SignOut(ctx context.Context, in *SignOutRequest, opts ...grpc.CallOption) (*Response, error)
+	// Revoke all access and refresh tokens of token owner, on every device.
+	SignOutAll(ctx context.Context, in *SignOutAllRequest, opts ...grpc.CallOption) (*Response, error)
+	// Parse JWT from string.
 	ParseToken(ctx context.Context, in *ParseRequest, opts ...grpc.CallOption) (*User, error)
+	// Add role to user. Requires admin token.
+	AssignRole(ctx context.Context, in *RoleRequest, opts ...grpc.CallOption) (*User, error)
+	// Remove role from user. Requires admin token.
+	RemoveRole(ctx context.Context, in *RoleRequest, opts ...grpc.CallOption) (*User, error)
+	// Grant permission to user. Requires admin token.
+	GrantPermission(ctx context.Context, in *GrantRequest, opts ...grpc.CallOption) (*User, error)
+	// Revoke permission granted to user. Requires admin token.
+	RevokePermission(ctx context.Context, in *GrantRequest, opts ...grpc.CallOption) (*User, error)
+	// Check if token owner has permission. Admin role has all permissions.
+	CheckPermission(ctx context.Context, in *PermissionRequest, opts ...grpc.CallOption) (*PermissionResponse, error)
+	// Public keys for offline JWT verification, in JWK Set format (RFC 7517).
+	GetJWKS(ctx context.Context, in *JWKSRequest, opts ...grpc.CallOption) (*JWKS, error)
 }
 
 type authServiceClient struct {
@@ -55,6 +85,15 @@ func (c *authServiceClient) SignIn(ctx context.Context, in *SignInRequest, opts
 	return out, nil
 }
 
+func (c *authServiceClient) RefreshToken(ctx context.Context, in *RefreshRequest, opts ...grpc.CallOption) (*SignInResponce, error) {
+	out := new(SignInResponce)
+	err := c.cc.Invoke(ctx, "/api.AuthService/RefreshToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *authServiceClient) Update(ctx context.Context, in *UpdRequest, opts ...grpc.CallOption) (*User, error) {
 	out := new(User)
 	err := c.cc.Invoke(ctx, "/api.AuthService/Update", in, out, opts...)
@@ -73,6 +112,24 @@ func (c *authServiceClient) Delete(ctx context.Context, in *DelRequest, opts ...
 	return out, nil
 }
 
+func (c *authServiceClient) SignOut(ctx context.Context, in *SignOutRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := c.cc.Invoke(ctx, "/api.AuthService/SignOut", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) SignOutAll(ctx context.Context, in *SignOutAllRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := c.cc.Invoke(ctx, "/api.AuthService/SignOutAll", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *authServiceClient) ParseToken(ctx context.Context, in *ParseRequest, opts ...grpc.CallOption) (*User, error) {
 	out := new(User)
 	err := c.cc.Invoke(ctx, "/api.AuthService/ParseToken", in, out, opts...)
@@ -82,15 +139,99 @@ func (c *authServiceClient) ParseToken(ctx context.Context, in *ParseRequest, op
 	return out, nil
 }
 
+func (c *authServiceClient) AssignRole(ctx context.Context, in *RoleRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/api.AuthService/AssignRole", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) RemoveRole(ctx context.Context, in *RoleRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/api.AuthService/RemoveRole", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) GrantPermission(ctx context.Context, in *GrantRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/api.AuthService/GrantPermission", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) RevokePermission(ctx context.Context, in *GrantRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/api.AuthService/RevokePermission", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) CheckPermission(ctx context.Context, in *PermissionRequest, opts ...grpc.CallOption) (*PermissionResponse, error) {
+	out := new(PermissionResponse)
+	err := c.cc.Invoke(ctx, "/api.AuthService/CheckPermission", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) GetJWKS(ctx context.Context, in *JWKSRequest, opts ...grpc.CallOption) (*JWKS, error) {
+	out := new(JWKS)
+	err := c.cc.Invoke(ctx, "/api.AuthService/GetJWKS", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // AuthServiceServer is the server API for AuthService service.
 // All implementations must embed UnimplementedAuthServiceServer
 // for forward compatibility
 type AuthServiceServer interface {
+	// Sign up user, based on username/password. Returns registered User.
 	SignUp(context.Context, *SignUpRequest) (*User, error)
+	// Sign in user, based on username/password. Returns short-lived JWT
+	// and long-lived refresh token.
 	SignIn(context.Context, *SignInRequest) (*SignInResponce, error)
+	// Exchange refresh token for a new JWT/refresh token pair.
+	// Reusing an already exchanged refresh token revokes the whole token family.
+	RefreshToken(context.Context, *RefreshRequest) (*SignInResponce, error)
+	// Update user account (username or password).
+	// Also using in Sing up case, to update user id from business-logic DB.
+	// Requires valid token. Only token owner's account can be updated,
+	// unless token owner has admin role.
 	Update(context.Context, *UpdRequest) (*User, error)
+	// Delete authorized user and revoke token.
+	// Only token owner's account can be deleted, unless token owner has admin role.
 	Delete(context.Context, *DelRequest) (*Response, error)
+	// Revoke passed access token, and the refresh token family when
+	// refresh token is given.
+	SignOut(context.Context, *SignOutRequest) (*Response, error)
+	// Revoke all access and refresh tokens of token owner, on every device.
+	SignOutAll(context.Context, *SignOutAllRequest) (*Response, error)
+	// Parse JWT from string.
 	ParseToken(context.Context, *ParseRequest) (*User, error)
+	// Add role to user. Requires admin token.
+	AssignRole(context.Context, *RoleRequest) (*User, error)
+	// Remove role from user. Requires admin token.
+	RemoveRole(context.Context, *RoleRequest) (*User, error)
+	// Grant permission to user. Requires admin token.
+	GrantPermission(context.Context, *GrantRequest) (*User, error)
+	// Revoke permission granted to user. Requires admin token.
+	RevokePermission(context.Context, *GrantRequest) (*User, error)
+	// Check if token owner has permission. Admin role has all permissions.
+	CheckPermission(context.Context, *PermissionRequest) (*PermissionResponse, error)
+	// Public keys for offline JWT verification, in JWK Set format (RFC 7517).
+	GetJWKS(context.Context, *JWKSRequest) (*JWKS, error)
 	mustEmbedUnimplementedAuthServiceServer()
 }
 
@@ -104,15 +245,42 @@ func (UnimplementedAuthServiceServer) SignUp(context.Context, *SignUpRequest) (*
 func (UnimplementedAuthServiceServer) SignIn(context.Context, *SignInRequest) (*SignInResponce, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SignIn not implemented")
 }
+func (UnimplementedAuthServiceServer) RefreshToken(context.Context, *RefreshRequest) (*SignInResponce, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RefreshToken not implemented")
+}
 func (UnimplementedAuthServiceServer) Update(context.Context, *UpdRequest) (*User, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
 }
 func (UnimplementedAuthServiceServer) Delete(context.Context, *DelRequest) (*Response, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
 }
+func (UnimplementedAuthServiceServer) SignOut(context.Context, *SignOutRequest) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SignOut not implemented")
+}
+func (UnimplementedAuthServiceServer) SignOutAll(context.Context, *SignOutAllRequest) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SignOutAll not implemented")
+}
 func (UnimplementedAuthServiceServer) ParseToken(context.Context, *ParseRequest) (*User, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ParseToken not implemented")
 }
+func (UnimplementedAuthServiceServer) AssignRole(context.Context, *RoleRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AssignRole not implemented")
+}
+func (UnimplementedAuthServiceServer) RemoveRole(context.Context, *RoleRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveRole not implemented")
+}
+func (UnimplementedAuthServiceServer) GrantPermission(context.Context, *GrantRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GrantPermission not implemented")
+}
+func (UnimplementedAuthServiceServer) RevokePermission(context.Context, *GrantRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokePermission not implemented")
+}
+func (UnimplementedAuthServiceServer) CheckPermission(context.Context, *PermissionRequest) (*PermissionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckPermission not implemented")
+}
+func (UnimplementedAuthServiceServer) GetJWKS(context.Context, *JWKSRequest) (*JWKS, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetJWKS not implemented")
+}
 func (UnimplementedAuthServiceServer) mustEmbedUnimplementedAuthServiceServer() {}
 
 // UnsafeAuthServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -162,6 +330,24 @@ func _AuthService_SignIn_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _AuthService_RefreshToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RefreshRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).RefreshToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.AuthService/RefreshToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).RefreshToken(ctx, req.(*RefreshRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _AuthService_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(UpdRequest)
 	if err := dec(in); err != nil {
@@ -198,6 +384,42 @@ func _AuthService_Delete_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _AuthService_SignOut_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SignOutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).SignOut(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.AuthService/SignOut",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).SignOut(ctx, req.(*SignOutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_SignOutAll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SignOutAllRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).SignOutAll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.AuthService/SignOutAll",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).SignOutAll(ctx, req.(*SignOutAllRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _AuthService_ParseToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(ParseRequest)
 	if err := dec(in); err != nil {
@@ -216,6 +438,114 @@ func _AuthService_ParseToken_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _AuthService_AssignRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RoleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).AssignRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.AuthService/AssignRole",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).AssignRole(ctx, req.(*RoleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_RemoveRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RoleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).RemoveRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.AuthService/RemoveRole",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).RemoveRole(ctx, req.(*RoleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_GrantPermission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GrantRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).GrantPermission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.AuthService/GrantPermission",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).GrantPermission(ctx, req.(*GrantRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_RevokePermission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GrantRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).RevokePermission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.AuthService/RevokePermission",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).RevokePermission(ctx, req.(*GrantRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_CheckPermission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PermissionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).CheckPermission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.AuthService/CheckPermission",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).CheckPermission(ctx, req.(*PermissionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_GetJWKS_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(JWKSRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).GetJWKS(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.AuthService/GetJWKS",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).GetJWKS(ctx, req.(*JWKSRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // AuthService_ServiceDesc is the grpc.ServiceDesc for AuthService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -231,6 +561,10 @@ var AuthService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SignIn",
 			Handler:    _AuthService_SignIn_Handler,
 		},
+		{
+			MethodName: "RefreshToken",
+			Handler:    _AuthService_RefreshToken_Handler,
+		},
 		{
 			MethodName: "Update",
 			Handler:    _AuthService_Update_Handler,
@@ -239,10 +573,42 @@ var AuthService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Delete",
 			Handler:    _AuthService_Delete_Handler,
 		},
+		{
+			MethodName: "SignOut",
+			Handler:    _AuthService_SignOut_Handler,
+		},
+		{
+			MethodName: "SignOutAll",
+			Handler:    _AuthService_SignOutAll_Handler,
+		},
 		{
 			MethodName: "ParseToken",
 			Handler:    _AuthService_ParseToken_Handler,
 		},
+		{
+			MethodName: "AssignRole",
+			Handler:    _AuthService_AssignRole_Handler,
+		},
+		{
+			MethodName: "RemoveRole",
+			Handler:    _AuthService_RemoveRole_Handler,
+		},
+		{
+			MethodName: "GrantPermission",
+			Handler:    _AuthService_GrantPermission_Handler,
+		},
+		{
+			MethodName: "RevokePermission",
+			Handler:    _AuthService_RevokePermission_Handler,
+		},
+		{
+			MethodName: "CheckPermission",
+			Handler:    _AuthService_CheckPermission_Handler,
+		},
+		{
+			MethodName: "GetJWKS",
+			Handler:    _AuthService_GetJWKS_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/auth.proto",
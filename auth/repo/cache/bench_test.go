@@ -0,0 +1,108 @@
+// nolint
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"example-grpc-auth/auth"
+	"example-grpc-auth/auth/repo/mongodb"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Revoked tokens in benchmark data set
+const benchRevoked = 10000
+
+// Token repo with fixed lookup latency, standing in for a database round trip
+type slowRepo struct {
+	auth.TokenRepo
+	revoked map[string]bool
+	latency time.Duration
+}
+
+func (r *slowRepo) IsRevoked(c context.Context, jti string) (bool, error) {
+	time.Sleep(r.latency)
+	return r.revoked[jti], nil
+}
+
+func (r *slowRepo) RevokedTokens(c context.Context, since time.Time) ([]string, error) {
+	ids := make([]string, 0, len(r.revoked))
+	for id := range r.revoked {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (r *slowRepo) RevokedUsers(c context.Context, since time.Time) (map[string]time.Time, error) {
+	return nil, nil
+}
+
+func BenchmarkIsRevoked(b *testing.B) {
+	repo := &slowRepo{revoked: make(map[string]bool), latency: 200 * time.Microsecond}
+	for n := 0; n < benchRevoked; n++ {
+		repo.revoked[fmt.Sprintf("revoked-%d", n)] = true
+	}
+	cached := NewTokenRepo(repo, repo, Options{})
+	if err := cached.Warm(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+
+	for name, r := range map[string]auth.TokenRepo{"plain": repo, "cached": cached} {
+		b.Run(name, func(b *testing.B) {
+			benchIsRevoked(b, r)
+		})
+	}
+}
+
+// Plain and cached mongodb.TokenRepo. Needs MongoDB at MONGO_TEST_URI.
+func BenchmarkIsRevoked_Mongo(b *testing.B) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		b.Skip("MONGO_TEST_URI not set")
+	}
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+	db := client.Database(fmt.Sprintf("bench_%d", time.Now().UnixNano()))
+	defer db.Drop(ctx)
+
+	repo := mongodb.NewTokenRepo(db)
+	for n := 0; n < benchRevoked; n++ {
+		if err := repo.RevokeToken(ctx, fmt.Sprintf("revoked-%d", n), time.Now().Add(time.Hour)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	cached := NewTokenRepo(repo, repo, Options{})
+	if err := cached.Warm(ctx); err != nil {
+		b.Fatal(err)
+	}
+
+	for name, r := range map[string]auth.TokenRepo{"plain": repo, "cached": cached} {
+		b.Run(name, func(b *testing.B) {
+			benchIsRevoked(b, r)
+		})
+	}
+}
+
+// Mostly valid tokens, as in production, with one revoked in a hundred
+func benchIsRevoked(b *testing.B, r auth.TokenRepo) {
+	ctx := context.Background()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		jti := fmt.Sprintf("valid-%d", n)
+		if n%100 == 0 {
+			jti = fmt.Sprintf("revoked-%d", n%benchRevoked)
+		}
+		if _, err := r.IsRevoked(ctx, jti); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
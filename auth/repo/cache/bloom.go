@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"hash/maphash"
+	"math"
+)
+
+// Bloom filter of token ids. Not safe for concurrent use.
+type bloom struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+	seed maphash.Seed
+}
+
+// Filter sized for n items with false positive rate p
+func newBloom(n int, p float64) *bloom {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloom{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+		seed: maphash.MakeSeed(),
+	}
+}
+
+func (b *bloom) add(id string) {
+	h1, h2 := b.hash(id)
+	for i := uint64(0); i < b.k; i++ {
+		n := (h1 + i*h2) % b.m
+		b.bits[n/64] |= 1 << (n % 64)
+	}
+}
+
+// False means id was never added
+func (b *bloom) mayContain(id string) bool {
+	h1, h2 := b.hash(id)
+	for i := uint64(0); i < b.k; i++ {
+		n := (h1 + i*h2) % b.m
+		if b.bits[n/64]&(1<<(n%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Two hashes for double hashing, derived from single 64-bit hash
+func (b *bloom) hash(id string) (uint64, uint64) {
+	var h maphash.Hash
+	h.SetSeed(b.seed)
+	h.WriteString(id)
+	sum := h.Sum64()
+	return sum & math.MaxUint32, sum>>32 | 1
+}
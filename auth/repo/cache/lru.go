@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Set of confirmed revoked token ids, least recently used dropped first.
+// Safe for concurrent use.
+type lru struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+func (l *lru) contains(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.items[id]
+	if ok {
+		l.order.MoveToFront(e)
+	}
+	return ok
+}
+
+func (l *lru) add(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.items[id]; ok {
+		l.order.MoveToFront(e)
+		return
+	}
+	l.items[id] = l.order.PushFront(id)
+	if l.order.Len() > l.size {
+		last := l.order.Back()
+		l.order.Remove(last)
+		delete(l.items, last.Value.(string))
+	}
+}
+
+func (l *lru) keys() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	keys := make([]string, 0, len(l.items))
+	for id := range l.items {
+		keys = append(keys, id)
+	}
+	return keys
+}
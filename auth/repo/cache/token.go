@@ -0,0 +1,256 @@
+// Package cache keeps token revocations in process, in front of auth.TokenRepo
+// backed by a database. Only tokens that may be revoked, according to a local
+// bloom filter, are looked up in the database.
+package cache
+
+import (
+	"context"
+	"example-grpc-auth/auth"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPollInterval      = 5 * time.Second
+	defaultRebuildInterval   = time.Hour
+	defaultLRUSize           = 10000
+	defaultFalsePositiveRate = 0.001
+
+	// Min number of ids the filter is sized for
+	minCapacity = 10000
+	// Polling overlap, covers clock difference between instances
+	clockSkew = 5 * time.Second
+)
+
+// Revocations feed, implemented by mongodb.TokenRepo
+type Source interface {
+	// Ids of unexpired tokens revoked at or after since
+	RevokedTokens(c context.Context, since time.Time) ([]string, error)
+	// User-wide revocation markers changed at or after since
+	RevokedUsers(c context.Context, since time.Time) (map[string]time.Time, error)
+}
+
+// Cache options
+type Options struct {
+	// How often revocations made by other instances are picked up, 5 seconds by default.
+	// Tokens revoked elsewhere may be accepted for this long.
+	PollInterval time.Duration
+	// How often the filter is rebuilt to drop expired tokens, 1 hour by default
+	RebuildInterval time.Duration
+	// Max confirmed revoked ids kept, 10000 by default
+	LRUSize int
+	// Bloom filter false positive rate, 0.001 by default
+	FalsePositiveRate float64
+}
+
+// Caching auth.TokenRepo decorator. Until first successful Warm every call
+// goes to the wrapped repo.
+type TokenRepo struct {
+	next    auth.TokenRepo
+	src     Source
+	opts    Options
+	revoked *lru
+
+	mu     sync.RWMutex
+	warm   bool
+	filter *bloom
+	users  map[string]time.Time
+	// Next poll picks up revocations made after this time
+	since time.Time
+}
+
+func NewTokenRepo(next auth.TokenRepo, src Source, o Options) *TokenRepo {
+	if o.PollInterval == 0 {
+		o.PollInterval = defaultPollInterval
+	}
+	if o.RebuildInterval == 0 {
+		o.RebuildInterval = defaultRebuildInterval
+	}
+	if o.LRUSize == 0 {
+		o.LRUSize = defaultLRUSize
+	}
+	if o.FalsePositiveRate == 0 {
+		o.FalsePositiveRate = defaultFalsePositiveRate
+	}
+	return &TokenRepo{
+		next:    next,
+		src:     src,
+		opts:    o,
+		revoked: newLRU(o.LRUSize),
+		filter:  newBloom(minCapacity, o.FalsePositiveRate),
+		users:   make(map[string]time.Time),
+	}
+}
+
+// Load all revocations from source, replacing current filter
+func (r *TokenRepo) Warm(c context.Context) error {
+	start := time.Now()
+
+	ids, err := r.src.RevokedTokens(c, time.Time{})
+	if err != nil {
+		return err
+	}
+	users, err := r.src.RevokedUsers(c, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	capacity := 2 * len(ids)
+	if capacity < minCapacity {
+		capacity = minCapacity
+	}
+	filter := newBloom(capacity, r.opts.FalsePositiveRate)
+	for _, id := range ids {
+		filter.add(id)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Tokens revoked locally while loading
+	for _, id := range r.revoked.keys() {
+		filter.add(id)
+	}
+	for id, t := range r.users {
+		if t.After(users[id]) {
+			users[id] = t
+		}
+	}
+	r.filter = filter
+	r.users = users
+	r.since = start.Add(-clockSkew)
+	r.warm = true
+	return nil
+}
+
+// Pick up revocations made since last poll
+func (r *TokenRepo) poll(c context.Context) error {
+	r.mu.RLock()
+	since := r.since
+	r.mu.RUnlock()
+	start := time.Now()
+
+	ids, err := r.src.RevokedTokens(c, since)
+	if err != nil {
+		return err
+	}
+	users, err := r.src.RevokedUsers(c, since)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range ids {
+		r.filter.add(id)
+	}
+	for id, t := range users {
+		if t.After(r.users[id]) {
+			r.users[id] = t
+		}
+	}
+	r.since = start.Add(-clockSkew)
+	return nil
+}
+
+// Keep cache fresh until ctx is done
+func (r *TokenRepo) Run(ctx context.Context) {
+	poll := time.NewTicker(r.opts.PollInterval)
+	defer poll.Stop()
+	rebuild := time.NewTicker(r.opts.RebuildInterval)
+	defer rebuild.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			r.mu.RLock()
+			warm := r.warm
+			r.mu.RUnlock()
+
+			var err error
+			if warm {
+				err = r.poll(ctx)
+			} else {
+				err = r.Warm(ctx)
+			}
+			if err != nil {
+				log.Printf("revocation cache refresh failed: %v", err)
+			}
+		case <-rebuild.C:
+			if err := r.Warm(ctx); err != nil {
+				log.Printf("revocation cache rebuild failed: %v", err)
+			}
+		}
+	}
+}
+
+func (r *TokenRepo) RevokeToken(c context.Context, jti string, exp time.Time) error {
+	if err := r.next.RevokeToken(c, jti, exp); err != nil {
+		return err
+	}
+	if !exp.After(time.Now()) {
+		return nil
+	}
+
+	r.revoked.add(jti)
+	r.mu.Lock()
+	r.filter.add(jti)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *TokenRepo) IsRevoked(c context.Context, jti string) (bool, error) {
+	r.mu.RLock()
+	warm := r.warm
+	maybe := r.filter.mayContain(jti)
+	r.mu.RUnlock()
+
+	if !warm {
+		return r.next.IsRevoked(c, jti)
+	}
+	if !maybe {
+		return false, nil
+	}
+	if r.revoked.contains(jti) {
+		return true, nil
+	}
+
+	// Possible hit, either revoked or false positive
+	revoked, err := r.next.IsRevoked(c, jti)
+	if err != nil {
+		return false, err
+	}
+	if revoked {
+		r.revoked.add(jti)
+	}
+	return revoked, nil
+}
+
+func (r *TokenRepo) RevokeUserTokens(c context.Context, userID string, before time.Time) error {
+	if err := r.next.RevokeUserTokens(c, userID, before); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	if before.After(r.users[userID]) {
+		r.users[userID] = before
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *TokenRepo) RevokedBefore(c context.Context, userID string) (time.Time, error) {
+	r.mu.RLock()
+	warm := r.warm
+	before := r.users[userID]
+	r.mu.RUnlock()
+
+	if !warm {
+		return r.next.RevokedBefore(c, userID)
+	}
+	return before, nil
+}
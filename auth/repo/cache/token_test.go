@@ -0,0 +1,251 @@
+// nolint
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"example-grpc-auth/auth"
+	"example-grpc-auth/auth/repo/memory"
+	"example-grpc-auth/auth/repo/mock"
+	"example-grpc-auth/auth/repotest"
+
+	mc "github.com/stretchr/testify/mock"
+)
+
+// In-memory revocations feed
+type fakeSource struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+	users  map[string]time.Time
+	err    error
+}
+
+func newFakeSource() *fakeSource {
+	return &fakeSource{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]time.Time),
+	}
+}
+
+func (s *fakeSource) revoke(jti string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[jti] = time.Now()
+}
+
+func (s *fakeSource) RevokedTokens(c context.Context, since time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	var ids []string
+	for id, t := range s.tokens {
+		if !t.Before(since) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *fakeSource) RevokedUsers(c context.Context, since time.Time) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	users := make(map[string]time.Time)
+	for id, t := range s.users {
+		users[id] = t
+	}
+	return users, nil
+}
+
+func countCalls(m *mock.TokenRepoMock, method string) int {
+	n := 0
+	for _, c := range m.Calls {
+		if c.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+func TestTokenRepo_IsRevoked(t *testing.T) {
+	src := newFakeSource()
+	src.revoke("revoked")
+
+	next := new(mock.TokenRepoMock)
+	next.On("IsRevoked", "revoked").Return(true, nil)
+	next.On("IsRevoked", mc.Anything).Return(false, nil)
+
+	r := NewTokenRepo(next, src, Options{})
+	if err := r.Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown ids never reach the wrapped repo, barring false positives
+	for n := 0; n < 1000; n++ {
+		ok, err := r.IsRevoked(context.Background(), fmt.Sprintf("token-%d", n))
+		if err != nil || ok {
+			t.Fatalf("IsRevoked() = %v, %v, want false", ok, err)
+		}
+	}
+	if calls := countCalls(next, "IsRevoked"); calls > 10 {
+		t.Errorf("wrapped IsRevoked called %d times for 1000 unknown tokens", calls)
+	}
+
+	// Revoked id is confirmed once, then served from LRU
+	before := countCalls(next, "IsRevoked")
+	for n := 0; n < 3; n++ {
+		ok, err := r.IsRevoked(context.Background(), "revoked")
+		if err != nil || !ok {
+			t.Fatalf("IsRevoked() = %v, %v, want true", ok, err)
+		}
+	}
+	if calls := countCalls(next, "IsRevoked") - before; calls != 1 {
+		t.Errorf("wrapped IsRevoked called %d times, want 1", calls)
+	}
+}
+
+func TestTokenRepo_NotWarm(t *testing.T) {
+	src := newFakeSource()
+	src.err = errors.New("unavailable")
+
+	next := new(mock.TokenRepoMock)
+	next.On("IsRevoked", "revoked").Return(true, nil)
+	next.On("RevokedBefore", "1").Return(time.Unix(100, 0), nil)
+
+	r := NewTokenRepo(next, src, Options{})
+	if err := r.Warm(context.Background()); err == nil {
+		t.Fatal("Warm() error = nil, want error")
+	}
+
+	// Empty filter must not hide revocations
+	if ok, _ := r.IsRevoked(context.Background(), "revoked"); !ok {
+		t.Error("IsRevoked() = false before warm up, want true")
+	}
+	if before, _ := r.RevokedBefore(context.Background(), "1"); !before.Equal(time.Unix(100, 0)) {
+		t.Errorf("RevokedBefore() = %v before warm up, want wrapped repo value", before)
+	}
+}
+
+func TestTokenRepo_RevokeToken(t *testing.T) {
+	next := new(mock.TokenRepoMock)
+	next.On("RevokeToken", mc.Anything, mc.Anything).Return(nil)
+	next.On("IsRevoked", mc.Anything).Return(false, nil)
+
+	r := NewTokenRepo(next, newFakeSource(), Options{})
+	if err := r.Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.RevokeToken(context.Background(), "local", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := r.IsRevoked(context.Background(), "local"); !ok {
+		t.Error("IsRevoked() = false for token revoked locally, want true")
+	}
+	next.AssertNotCalled(t, "IsRevoked", "local")
+
+	// Local revocations survive rebuild
+	if err := r.Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := r.IsRevoked(context.Background(), "local"); !ok {
+		t.Error("IsRevoked() = false after rebuild, want true")
+	}
+}
+
+func TestTokenRepo_Poll(t *testing.T) {
+	src := newFakeSource()
+	next := new(mock.TokenRepoMock)
+	next.On("IsRevoked", "remote").Return(true, nil)
+	next.On("IsRevoked", mc.Anything).Return(false, nil)
+
+	r := NewTokenRepo(next, src, Options{PollInterval: 10 * time.Millisecond})
+	if err := r.Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	// Revoked by another instance
+	src.revoke("remote")
+	src.mu.Lock()
+	src.users["1"] = time.Unix(200, 0)
+	src.mu.Unlock()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		ok, _ := r.IsRevoked(context.Background(), "remote")
+		before, _ := r.RevokedBefore(context.Background(), "1")
+		if ok && before.Equal(time.Unix(200, 0)) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("revocations not picked up: IsRevoked() = %v, RevokedBefore() = %v", ok, before)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTokenRepo_RevokeUserTokens(t *testing.T) {
+	next := new(mock.TokenRepoMock)
+	next.On("RevokeUserTokens", "1", mc.Anything).Return(nil)
+
+	r := NewTokenRepo(next, newFakeSource(), Options{})
+	if err := r.Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if err := r.RevokeUserTokens(context.Background(), "1", now); err != nil {
+		t.Fatal(err)
+	}
+	// Marker never moves back
+	if err := r.RevokeUserTokens(context.Background(), "1", now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if before, _ := r.RevokedBefore(context.Background(), "1"); !before.Equal(now) {
+		t.Errorf("RevokedBefore() = %v, want %v", before, now)
+	}
+	next.AssertNotCalled(t, "RevokedBefore", mc.Anything)
+}
+
+func TestBloom_FalsePositiveRate(t *testing.T) {
+	b := newBloom(10000, 0.01)
+	for n := 0; n < 10000; n++ {
+		b.add(fmt.Sprintf("in-%d", n))
+	}
+	for n := 0; n < 10000; n++ {
+		if !b.mayContain(fmt.Sprintf("in-%d", n)) {
+			t.Fatalf("mayContain(in-%d) = false for added id", n)
+		}
+	}
+	fp := 0
+	for n := 0; n < 10000; n++ {
+		if b.mayContain(fmt.Sprintf("out-%d", n)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 10000; rate > 0.02 {
+		t.Errorf("false positive rate = %v, want about 0.01", rate)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	repotest.RunTokenRepo(t, func(t *testing.T) auth.TokenRepo {
+		r := NewTokenRepo(memory.NewTokenRepo(), newFakeSource(), Options{})
+		if err := r.Warm(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	})
+}
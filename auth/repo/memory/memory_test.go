@@ -0,0 +1,56 @@
+// nolint
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"example-grpc-auth/auth"
+	"example-grpc-auth/auth/repotest"
+	"example-grpc-auth/models"
+)
+
+func TestConformance(t *testing.T) {
+	t.Run("UserRepo", func(t *testing.T) {
+		repotest.RunUserRepo(t, func(t *testing.T) auth.UserRepo { return NewUserRepo() })
+	})
+	t.Run("TokenRepo", func(t *testing.T) {
+		repotest.RunTokenRepo(t, func(t *testing.T) auth.TokenRepo { return NewTokenRepo() })
+	})
+	t.Run("RefreshTokenRepo", func(t *testing.T) {
+		repotest.RunRefreshTokenRepo(t, func(t *testing.T) auth.RefreshTokenRepo { return NewRefreshTokenRepo() })
+	})
+}
+
+func TestPurgeExpired(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	tokens := NewTokenRepo()
+	tokens.RevokeToken(ctx, "short", now.Add(20*time.Millisecond))
+	tokens.RevokeToken(ctx, "long", now.Add(time.Hour))
+	refresh := NewRefreshTokenRepo()
+	refresh.CreateRefreshToken(ctx, &models.RefreshToken{ID: "expired", FamilyID: "f1", ExpiresAt: now.Add(-time.Minute)})
+	refresh.CreateRefreshToken(ctx, &models.RefreshToken{ID: "valid", FamilyID: "f2", ExpiresAt: now.Add(time.Hour)})
+
+	time.Sleep(30 * time.Millisecond)
+	// Revocation doesn't sweep, expired tokens stay until purged
+	tokens.RevokeToken(ctx, "other", now.Add(time.Hour))
+	if _, ok := tokens.tokens["short"]; !ok {
+		t.Error("RevokeToken() dropped expired token")
+	}
+
+	if err := tokens.PurgeExpired(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := refresh.PurgeExpired(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tokens.tokens["short"]; ok || len(tokens.tokens) != 2 {
+		t.Errorf("revoked tokens after purge = %v, want long and other", tokens.tokens)
+	}
+	if _, ok := refresh.tokens["expired"]; ok || len(refresh.tokens) != 1 {
+		t.Errorf("refresh tokens after purge = %v, want valid only", refresh.tokens)
+	}
+}
@@ -0,0 +1,99 @@
+package memory
+
+import (
+	"context"
+	e "example-grpc-auth/err"
+	"example-grpc-auth/models"
+	"sync"
+	"time"
+)
+
+// In-memory refresh token storage. Safe for concurrent use.
+// Expired tokens are kept until PurgeExpired.
+type RefreshTokenRepo struct {
+	mu     sync.Mutex
+	tokens map[string]models.RefreshToken
+}
+
+func NewRefreshTokenRepo() *RefreshTokenRepo {
+	return &RefreshTokenRepo{
+		tokens: make(map[string]models.RefreshToken),
+	}
+}
+
+func (r *RefreshTokenRepo) CreateRefreshToken(c context.Context, t *models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens[t.ID] = *t
+	return nil
+}
+
+func (r *RefreshTokenRepo) GetRefreshToken(c context.Context, id string) (*models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[id]
+	if !ok {
+		return nil, e.ErrInvalidRefresh
+	}
+	return &token, nil
+}
+
+func (r *RefreshTokenRepo) UseRefreshToken(c context.Context, id string) (*models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[id]
+	if !ok {
+		return nil, e.ErrInvalidRefresh
+	}
+	before := token
+
+	token.Used = true
+	r.tokens[id] = token
+
+	return &before, nil
+}
+
+func (r *RefreshTokenRepo) RevokeFamily(c context.Context, familyID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, t := range r.tokens {
+		if t.FamilyID == familyID {
+			t.Revoked = true
+			r.tokens[id] = t
+		}
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepo) RevokeUserFamilies(c context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, t := range r.tokens {
+		if t.User.ID == userID {
+			t.Revoked = true
+			r.tokens[id] = t
+		}
+	}
+	return nil
+}
+
+// Delete tokens past their expiration, in place of TTL index.
+// Families are gone once their last token expires.
+func (r *RefreshTokenRepo) PurgeExpired(c context.Context) error {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, t := range r.tokens {
+		if !t.ExpiresAt.After(now) {
+			delete(r.tokens, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// In-memory revoked tokens storage. Safe for concurrent use.
+// Expired tokens are kept until PurgeExpired.
+type TokenRepo struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+	users  map[string]time.Time
+}
+
+func NewTokenRepo() *TokenRepo {
+	return &TokenRepo{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]time.Time),
+	}
+}
+
+func (t *TokenRepo) RevokeToken(c context.Context, jti string, exp time.Time) error {
+	now := time.Now()
+	// Expired token can't be used anyway
+	if !exp.After(now) {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tokens[jti] = exp
+	return nil
+}
+
+// Delete tokens past their expiration, in place of TTL index
+func (t *TokenRepo) PurgeExpired(c context.Context) error {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for jti, exp := range t.tokens {
+		if !exp.After(now) {
+			delete(t.tokens, jti)
+		}
+	}
+	return nil
+}
+
+func (t *TokenRepo) IsRevoked(c context.Context, jti string) (bool, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	exp, ok := t.tokens[jti]
+	return ok && exp.After(time.Now()), nil
+}
+
+func (t *TokenRepo) RevokeUserTokens(c context.Context, userID string, before time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Never move the marker back
+	if before.After(t.users[userID]) {
+		t.users[userID] = before
+	}
+	return nil
+}
+
+func (t *TokenRepo) RevokedBefore(c context.Context, userID string) (time.Time, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.users[userID], nil
+}
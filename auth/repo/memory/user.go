@@ -0,0 +1,224 @@
+package memory
+
+import (
+	"context"
+	e "example-grpc-auth/err"
+	"example-grpc-auth/models"
+	"sort"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// In-memory users storage. Safe for concurrent use.
+type UserRepo struct {
+	mu     sync.RWMutex
+	lastID int
+	users  map[string]*models.User
+}
+
+func NewUserRepo() *UserRepo {
+	return &UserRepo{
+		users: make(map[string]*models.User),
+	}
+}
+
+func (r *UserRepo) CreateUser(c context.Context, u string, p string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.byUsername(u) != nil {
+		return e.ErrDupKey
+	}
+	r.lastID++
+	id := strconv.Itoa(r.lastID)
+	r.users[id] = &models.User{
+		ID:       id,
+		Username: models.NormUsername(u),
+		Password: p,
+	}
+	return nil
+}
+
+func (r *UserRepo) GetUser(c context.Context, u string, p string) (*models.User, error) {
+	r.mu.RLock()
+	user := r.byUsername(u)
+	if user != nil {
+		user = clone(user)
+	}
+	r.mu.RUnlock()
+
+	if user == nil {
+		return nil, e.ErrUserNotFound
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(p)); err != nil {
+		return nil, e.ErrInvalidCred
+	}
+	return user, nil
+}
+
+func (r *UserRepo) GetUserByID(c context.Context, id string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, e.ErrUserNotFound
+	}
+	return clone(user), nil
+}
+
+func (r *UserRepo) DeleteUser(c context.Context, u *models.User) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user := r.find(u)
+	if user == nil {
+		return nil, e.ErrUserNotFound
+	}
+	delete(r.users, user.ID)
+	return clone(user), nil
+}
+
+func (r *UserRepo) UpdateUser(c context.Context, filt *models.User, upd *models.User) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user := r.find(filt)
+	if user == nil {
+		return nil, e.ErrUserNotFound
+	}
+	if upd.Username != "" {
+		// Own username in other case is not a duplicate
+		if other := r.byUsername(upd.Username); other != nil && other != user {
+			return nil, e.ErrDupKey
+		}
+	}
+
+	// Zero fields are left as is
+	if upd.MysqlID != 0 {
+		user.MysqlID = upd.MysqlID
+	}
+	if upd.Username != "" {
+		user.Username = models.NormUsername(upd.Username)
+	}
+	if upd.Password != "" {
+		user.Password = upd.Password
+	}
+	if len(upd.Roles) > 0 {
+		user.Roles = unique(upd.Roles)
+	}
+	if len(upd.Permissions) > 0 {
+		user.Permissions = unique(upd.Permissions)
+	}
+	return clone(user), nil
+}
+
+func (r *UserRepo) AddRole(c context.Context, id string, role string) (*models.User, error) {
+	return r.change(id, func(u *models.User) { u.Roles = add(u.Roles, role) })
+}
+
+func (r *UserRepo) RemoveRole(c context.Context, id string, role string) (*models.User, error) {
+	return r.change(id, func(u *models.User) { u.Roles = remove(u.Roles, role) })
+}
+
+func (r *UserRepo) AddPermission(c context.Context, id string, perm string) (*models.User, error) {
+	return r.change(id, func(u *models.User) { u.Permissions = add(u.Permissions, perm) })
+}
+
+func (r *UserRepo) RemovePermission(c context.Context, id string, perm string) (*models.User, error) {
+	return r.change(id, func(u *models.User) { u.Permissions = remove(u.Permissions, perm) })
+}
+
+func (r *UserRepo) change(id string, fn func(*models.User)) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, e.ErrUserNotFound
+	}
+	fn(user)
+	return clone(user), nil
+}
+
+func add(values []string, v string) []string {
+	for _, x := range values {
+		if x == v {
+			return values
+		}
+	}
+	return append(values, v)
+}
+
+func remove(values []string, v string) []string {
+	kept := values[:0]
+	for _, x := range values {
+		if x != v {
+			kept = append(kept, x)
+		}
+	}
+	return kept
+}
+
+// User with username equal up to case and normalization
+func (r *UserRepo) byUsername(u string) *models.User {
+	key := models.UsernameKey(u)
+	for _, user := range r.users {
+		if models.UsernameKey(user.Username) == key {
+			return user
+		}
+	}
+	return nil
+}
+
+// First user, by id, matching non-zero id, mysql_id and username of filter
+func (r *UserRepo) find(filt *models.User) *models.User {
+	// Empty filter would match anyone
+	if filt.ID == "" && filt.MysqlID == 0 && filt.Username == "" {
+		return nil
+	}
+
+	var key string
+	if filt.Username != "" {
+		key = models.UsernameKey(filt.Username)
+	}
+
+	ids := make([]int, 0, len(r.users))
+	for id := range r.users {
+		n, _ := strconv.Atoi(id)
+		ids = append(ids, n)
+	}
+	sort.Ints(ids)
+
+	for _, n := range ids {
+		user := r.users[strconv.Itoa(n)]
+		if (filt.ID == "" || filt.ID == user.ID) &&
+			(filt.MysqlID == 0 || filt.MysqlID == user.MysqlID) &&
+			(filt.Username == "" || key == models.UsernameKey(user.Username)) {
+			return user
+		}
+	}
+	return nil
+}
+
+// Copy, so callers can't modify stored user
+func clone(u *models.User) *models.User {
+	c := *u
+	c.Roles = append([]string(nil), u.Roles...)
+	c.Permissions = append([]string(nil), u.Permissions...)
+	return &c
+}
+
+func unique(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var res []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			res = append(res, v)
+		}
+	}
+	return res
+}
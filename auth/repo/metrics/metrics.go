@@ -0,0 +1,164 @@
+// Package metrics records Prometheus call counts and latency of auth.UserRepo
+// and auth.TokenRepo implementations it wraps.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"example-grpc-auth/auth"
+	e "example-grpc-auth/err"
+	"example-grpc-auth/models"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	calls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_repo_calls_total",
+		Help: "Repository calls by backend, method and result: ok, rejected or error.",
+	}, []string{"backend", "method", "result"})
+	latency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "auth_repo_call_duration_seconds",
+		Help:    "Repository call latency by backend and method.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"backend", "method"})
+)
+
+// Errors answering the call, as opposed to failing it
+var rejections = []error{e.ErrUserNotFound, e.ErrInvalidCred, e.ErrDupKey}
+
+func result(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	for _, r := range rejections {
+		if errors.Is(err, r) {
+			return "rejected"
+		}
+	}
+	return "error"
+}
+
+type observer struct {
+	backend string
+}
+
+func (o observer) observe(method string, start time.Time, err error) {
+	latency.WithLabelValues(o.backend, method).Observe(time.Since(start).Seconds())
+	calls.WithLabelValues(o.backend, method, result(err)).Inc()
+}
+
+// Metered auth.UserRepo decorator
+type UserRepo struct {
+	next auth.UserRepo
+	observer
+}
+
+// Wrap repo, backend is the metrics label, e.g. "mongodb"
+func NewUserRepo(next auth.UserRepo, backend string) *UserRepo {
+	return &UserRepo{next: next, observer: observer{backend}}
+}
+
+func (r *UserRepo) CreateUser(c context.Context, u string, p string) error {
+	start := time.Now()
+	err := r.next.CreateUser(c, u, p)
+	r.observe("CreateUser", start, err)
+	return err
+}
+
+func (r *UserRepo) GetUser(c context.Context, u string, p string) (*models.User, error) {
+	start := time.Now()
+	v, err := r.next.GetUser(c, u, p)
+	r.observe("GetUser", start, err)
+	return v, err
+}
+
+func (r *UserRepo) UpdateUser(c context.Context, f *models.User, u *models.User) (*models.User, error) {
+	start := time.Now()
+	v, err := r.next.UpdateUser(c, f, u)
+	r.observe("UpdateUser", start, err)
+	return v, err
+}
+
+func (r *UserRepo) DeleteUser(c context.Context, u *models.User) (*models.User, error) {
+	start := time.Now()
+	v, err := r.next.DeleteUser(c, u)
+	r.observe("DeleteUser", start, err)
+	return v, err
+}
+
+func (r *UserRepo) GetUserByID(c context.Context, id string) (*models.User, error) {
+	start := time.Now()
+	v, err := r.next.GetUserByID(c, id)
+	r.observe("GetUserByID", start, err)
+	return v, err
+}
+
+func (r *UserRepo) AddRole(c context.Context, id string, role string) (*models.User, error) {
+	start := time.Now()
+	v, err := r.next.AddRole(c, id, role)
+	r.observe("AddRole", start, err)
+	return v, err
+}
+
+func (r *UserRepo) RemoveRole(c context.Context, id string, role string) (*models.User, error) {
+	start := time.Now()
+	v, err := r.next.RemoveRole(c, id, role)
+	r.observe("RemoveRole", start, err)
+	return v, err
+}
+
+func (r *UserRepo) AddPermission(c context.Context, id string, perm string) (*models.User, error) {
+	start := time.Now()
+	v, err := r.next.AddPermission(c, id, perm)
+	r.observe("AddPermission", start, err)
+	return v, err
+}
+
+func (r *UserRepo) RemovePermission(c context.Context, id string, perm string) (*models.User, error) {
+	start := time.Now()
+	v, err := r.next.RemovePermission(c, id, perm)
+	r.observe("RemovePermission", start, err)
+	return v, err
+}
+
+// Metered auth.TokenRepo decorator
+type TokenRepo struct {
+	next auth.TokenRepo
+	observer
+}
+
+// Wrap repo, backend is the metrics label, e.g. "redis"
+func NewTokenRepo(next auth.TokenRepo, backend string) *TokenRepo {
+	return &TokenRepo{next: next, observer: observer{backend}}
+}
+
+func (r *TokenRepo) RevokeToken(c context.Context, jti string, exp time.Time) error {
+	start := time.Now()
+	err := r.next.RevokeToken(c, jti, exp)
+	r.observe("RevokeToken", start, err)
+	return err
+}
+
+func (r *TokenRepo) IsRevoked(c context.Context, jti string) (bool, error) {
+	start := time.Now()
+	v, err := r.next.IsRevoked(c, jti)
+	r.observe("IsRevoked", start, err)
+	return v, err
+}
+
+func (r *TokenRepo) RevokeUserTokens(c context.Context, userID string, before time.Time) error {
+	start := time.Now()
+	err := r.next.RevokeUserTokens(c, userID, before)
+	r.observe("RevokeUserTokens", start, err)
+	return err
+}
+
+func (r *TokenRepo) RevokedBefore(c context.Context, userID string) (time.Time, error) {
+	start := time.Now()
+	v, err := r.next.RevokedBefore(c, userID)
+	r.observe("RevokedBefore", start, err)
+	return v, err
+}
@@ -0,0 +1,64 @@
+// nolint
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"example-grpc-auth/auth"
+	"example-grpc-auth/auth/repo/memory"
+	"example-grpc-auth/auth/repotest"
+	e "example-grpc-auth/err"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestConformance(t *testing.T) {
+	t.Run("UserRepo", func(t *testing.T) {
+		repotest.RunUserRepo(t, func(t *testing.T) auth.UserRepo { return NewUserRepo(memory.NewUserRepo(), "memory") })
+	})
+	t.Run("TokenRepo", func(t *testing.T) {
+		repotest.RunTokenRepo(t, func(t *testing.T) auth.TokenRepo { return NewTokenRepo(memory.NewTokenRepo(), "memory") })
+	})
+}
+
+func TestResult(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, "ok"},
+		{e.ErrUserNotFound, "rejected"},
+		{e.ErrInvalidCred, "rejected"},
+		{e.ErrDupKey, "rejected"},
+		{errors.New("connection refused"), "error"},
+	}
+	for _, tt := range tests {
+		if got := result(tt.err); got != tt.want {
+			t.Errorf("result(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestUserRepo_Observe(t *testing.T) {
+	r := NewUserRepo(memory.NewUserRepo(), t.Name())
+	ctx := context.Background()
+
+	if err := r.CreateUser(ctx, "alice", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.CreateUser(ctx, "alice", "hash"); !errors.Is(err, e.ErrDupKey) {
+		t.Fatalf("CreateUser() error = %v, want %v", err, e.ErrDupKey)
+	}
+
+	if got := testutil.ToFloat64(calls.WithLabelValues(t.Name(), "CreateUser", "ok")); got != 1 {
+		t.Errorf("ok calls = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(calls.WithLabelValues(t.Name(), "CreateUser", "rejected")); got != 1 {
+		t.Errorf("rejected calls = %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(latency, "auth_repo_call_duration_seconds"); got == 0 {
+		t.Error("no latency observed")
+	}
+}
@@ -0,0 +1,34 @@
+// nolint
+package mock
+
+import (
+	"context"
+	"example-grpc-auth/models"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type RefreshTokenRepoMock struct {
+	mock.Mock
+}
+
+func (m *RefreshTokenRepoMock) CreateRefreshToken(c context.Context, t *models.RefreshToken) error {
+	args := m.Called(t)
+	return args.Error(0)
+}
+func (m *RefreshTokenRepoMock) GetRefreshToken(c context.Context, id string) (*models.RefreshToken, error) {
+	args := m.Called(id)
+	return args.Get(0).(*models.RefreshToken), args.Error(1)
+}
+func (m *RefreshTokenRepoMock) UseRefreshToken(c context.Context, id string) (*models.RefreshToken, error) {
+	args := m.Called(id)
+	return args.Get(0).(*models.RefreshToken), args.Error(1)
+}
+func (m *RefreshTokenRepoMock) RevokeFamily(c context.Context, familyID string) error {
+	args := m.Called(familyID)
+	return args.Error(0)
+}
+func (m *RefreshTokenRepoMock) RevokeUserFamilies(c context.Context, userID string) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
@@ -3,6 +3,7 @@ package mock
 
 import (
 	"context"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 )
@@ -11,11 +12,19 @@ type TokenRepoMock struct {
 	mock.Mock
 }
 
-func (m *TokenRepoMock) RevokeToken(c context.Context, t string) error {
-	args := m.Called(t)
+func (m *TokenRepoMock) RevokeToken(c context.Context, t string, exp time.Time) error {
+	args := m.Called(t, exp)
 	return args.Error(0)
 }
 func (m *TokenRepoMock) IsRevoked(c context.Context, t string) (bool, error) {
 	args := m.Called(t)
 	return args.Get(0).(bool), args.Error(1)
 }
+func (m *TokenRepoMock) RevokeUserTokens(c context.Context, userID string, before time.Time) error {
+	args := m.Called(userID, before)
+	return args.Error(0)
+}
+func (m *TokenRepoMock) RevokedBefore(c context.Context, userID string) (time.Time, error) {
+	args := m.Called(userID)
+	return args.Get(0).(time.Time), args.Error(1)
+}
@@ -24,8 +24,27 @@ func (m *UserRepoMock) UpdateUser(c context.Context, f *models.User, u *models.U
 	args := m.Called(f, u)
 	return args.Get(0).(*models.User), args.Error(1)
 }
-func (m *UserRepoMock) DeleteUser(c context.Context, u *models.User) error {
+func (m *UserRepoMock) DeleteUser(c context.Context, u *models.User) (*models.User, error) {
 	args := m.Called(u)
-	return args.Error(0)
-
+	return args.Get(0).(*models.User), args.Error(1)
+}
+func (m *UserRepoMock) GetUserByID(c context.Context, id string) (*models.User, error) {
+	args := m.Called(id)
+	return args.Get(0).(*models.User), args.Error(1)
+}
+func (m *UserRepoMock) AddRole(c context.Context, id string, role string) (*models.User, error) {
+	args := m.Called(id, role)
+	return args.Get(0).(*models.User), args.Error(1)
+}
+func (m *UserRepoMock) RemoveRole(c context.Context, id string, role string) (*models.User, error) {
+	args := m.Called(id, role)
+	return args.Get(0).(*models.User), args.Error(1)
+}
+func (m *UserRepoMock) AddPermission(c context.Context, id string, perm string) (*models.User, error) {
+	args := m.Called(id, perm)
+	return args.Get(0).(*models.User), args.Error(1)
+}
+func (m *UserRepoMock) RemovePermission(c context.Context, id string, perm string) (*models.User, error) {
+	args := m.Called(id, perm)
+	return args.Get(0).(*models.User), args.Error(1)
 }
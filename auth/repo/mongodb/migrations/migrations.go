@@ -0,0 +1,178 @@
+// Package migrations versions MongoDB collections setup: indexes, TTLs and
+// backfills of fields added later. Applied versions are recorded in the
+// migrations collection, so each runs once per database.
+//
+// Migrations are never edited once released, new ones are appended.
+// They must be idempotent: instances starting together may run one twice.
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	migrationsT   = "migrations"
+	indexNotFound = 27
+	// Lifetime of tokens issued before it became configurable
+	legacyTokenTTL = 24 * time.Hour
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(c context.Context, db *mongo.Database) error
+}
+
+// Applied migration record
+type applied struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// All migrations, in version order
+var All = []Migration{
+	{1, "revoked tokens indexes", revokedTokensIndexes},
+	{2, "refresh tokens indexes", refreshTokensIndexes},
+	{3, "backfill revoked users updated_at", backfillRevokedUsersUpdatedAt},
+	{4, "unique case-insensitive username index", uniqueUsernameIndex},
+	{5, "backfill revoked tokens expires_at", backfillRevokedTokensExpiresAt},
+	{6, "refresh tokens user index", refreshTokensUserIndex},
+}
+
+// Apply migrations not applied yet, stopping at the first failure
+func Run(c context.Context, db *mongo.Database) error {
+	return run(c, db, All)
+}
+
+func run(c context.Context, db *mongo.Database, all []Migration) error {
+	done, err := Applied(c, db)
+	if err != nil {
+		return err
+	}
+
+	cur := db.Collection(migrationsT)
+	for _, m := range all {
+		if done[m.Version] {
+			continue
+		}
+		if err := m.Up(c, db); err != nil {
+			return fmt.Errorf("migration %d %q: %w", m.Version, m.Name, err)
+		}
+
+		_, err := cur.InsertOne(c, applied{Version: m.Version, Name: m.Name, AppliedAt: time.Now()})
+		// Recorded by another instance meanwhile
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		log.Printf("applied MongoDB migration %d %q", m.Version, m.Name)
+	}
+	return nil
+}
+
+// Versions applied to db
+func Applied(c context.Context, db *mongo.Database) (map[int]bool, error) {
+	res, err := db.Collection(migrationsT).Find(c, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close(c)
+
+	done := make(map[int]bool)
+	for res.Next(c) {
+		var a applied
+		if err := res.Decode(&a); err != nil {
+			return nil, err
+		}
+		done[a.Version] = true
+	}
+	return done, res.Err()
+}
+
+// TTL index, so revoked tokens are removed once they would have expired anyway,
+// and indexes for polling recent revocations
+func revokedTokensIndexes(c context.Context, db *mongo.Database) error {
+	_, err := db.Collection("revokedTokens").Indexes().CreateMany(c, []mongo.IndexModel{{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}, {
+		Keys: bson.D{{Key: "revoketion_date", Value: 1}},
+	}})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection("revokedUsers").Indexes().CreateOne(c, mongo.IndexModel{
+		Keys: bson.D{{Key: "updated_at", Value: 1}},
+	})
+	return err
+}
+
+// Family lookup on reuse detection, TTL of expired tokens
+func refreshTokensIndexes(c context.Context, db *mongo.Database) error {
+	_, err := db.Collection("refreshTokens").Indexes().CreateMany(c, []mongo.IndexModel{{
+		Keys: bson.D{{Key: "family_id", Value: 1}},
+	}, {
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}})
+	return err
+}
+
+// Markers set before updated_at was introduced, so revocation cache polling sees them
+func backfillRevokedUsersUpdatedAt(c context.Context, db *mongo.Database) error {
+	_, err := db.Collection("revokedUsers").UpdateMany(c,
+		bson.M{"updated_at": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"updated_at": "$not_before"}}}})
+	return err
+}
+
+// Fails while duplicate usernames exist, see cmd/dupusers
+func uniqueUsernameIndex(c context.Context, db *mongo.Database) error {
+	cur := db.Collection("users")
+
+	_, err := cur.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys: bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetName("username_ci").SetUnique(true).
+			SetCollation(&options.Collation{Locale: "en", Strength: 2}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Plain index of mongo-init.js, superseded
+	_, err = cur.Indexes().DropOne(c, "username_1")
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.HasErrorCode(indexNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Tokens revoked before expires_at was introduced, so the TTL index removes them.
+// They were issued with the legacy lifetime, at the latest when revoked.
+func backfillRevokedTokensExpiresAt(c context.Context, db *mongo.Database) error {
+	_, err := db.Collection("revokedTokens").UpdateMany(c,
+		bson.M{"expires_at": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"expires_at": bson.M{"$add": bson.A{
+			bson.M{"$ifNull": bson.A{"$revoketion_date", "$$NOW"}},
+			legacyTokenTTL.Milliseconds(),
+		}}}}}})
+	return err
+}
+
+// User lookup on revoking all refresh token families of a user
+func refreshTokensUserIndex(c context.Context, db *mongo.Database) error {
+	_, err := db.Collection("refreshTokens").Indexes().CreateOne(c, mongo.IndexModel{
+		Keys: bson.D{{Key: "user._id", Value: 1}},
+	})
+	return err
+}
@@ -0,0 +1,130 @@
+// nolint
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Empty database of MongoDB at MONGO_TEST_URI, local mongod by default.
+// Tests are skipped when it's not reachable.
+func newTestDB(t *testing.T) *mongo.Database {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		uri = "mongodb://localhost:27017"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Skipf("MongoDB at %s: %v", uri, err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Skipf("MongoDB at %s: %v", uri, err)
+	}
+
+	db := client.Database(fmt.Sprintf("test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() { db.Drop(context.Background()) })
+	return db
+}
+
+func TestVersions(t *testing.T) {
+	for n, m := range All {
+		if m.Version != n+1 {
+			t.Errorf("migration %q has version %d, want %d", m.Name, m.Version, n+1)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	// Marker set before updated_at was introduced
+	notBefore := time.Now().Truncate(time.Millisecond)
+	_, err := db.Collection("revokedUsers").InsertOne(ctx, bson.M{"_id": "user-1", "not_before": notBefore})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Token revoked before expires_at was introduced
+	revokedAt := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	_, err = db.Collection("revokedTokens").InsertOne(ctx, bson.M{"_id": "legacy", "revoketion_date": revokedAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Run(ctx, db); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	// Applied migrations are skipped
+	if err := Run(ctx, db); err != nil {
+		t.Fatalf("Run() twice error = %v", err)
+	}
+	done, err := Applied(ctx, db)
+	if err != nil || len(done) != len(All) {
+		t.Errorf("Applied() = %v, %v, want all %d", done, err, len(All))
+	}
+
+	var marker struct {
+		UpdatedAt time.Time `bson:"updated_at"`
+	}
+	if err := db.Collection("revokedUsers").FindOne(ctx, bson.M{"_id": "user-1"}).Decode(&marker); err != nil {
+		t.Fatal(err)
+	}
+	if !marker.UpdatedAt.Equal(notBefore) {
+		t.Errorf("backfilled updated_at = %v, want %v", marker.UpdatedAt, notBefore)
+	}
+
+	var token struct {
+		ExpiresAt time.Time `bson:"expires_at"`
+	}
+	if err := db.Collection("revokedTokens").FindOne(ctx, bson.M{"_id": "legacy"}).Decode(&token); err != nil {
+		t.Fatal(err)
+	}
+	if want := revokedAt.Add(legacyTokenTTL); !token.ExpiresAt.Equal(want) {
+		t.Errorf("backfilled expires_at = %v, want %v", token.ExpiresAt, want)
+	}
+
+	_, err = db.Collection("users").InsertMany(ctx, []interface{}{bson.M{"username": "alice"}, bson.M{"username": "ALICE"}})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("insert of duplicate username error = %v, want duplicate key", err)
+	}
+}
+
+func TestRun_StopsOnFailure(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+
+	var ran []int
+	up := func(v int, err error) func(context.Context, *mongo.Database) error {
+		return func(context.Context, *mongo.Database) error {
+			ran = append(ran, v)
+			return err
+		}
+	}
+	failure := errors.New("failure")
+	all := []Migration{{1, "first", up(1, nil)}, {2, "second", up(2, failure)}, {3, "third", up(3, nil)}}
+
+	if err := run(ctx, db, all); !errors.Is(err, failure) {
+		t.Fatalf("run() error = %v, want %v", err, failure)
+	}
+	all[1].Up = up(2, nil)
+	if err := run(ctx, db, all); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	// Failed migration is retried, applied one is not
+	if fmt.Sprint(ran) != "[1 2 2 3]" {
+		t.Errorf("migrations ran %v, want [1 2 2 3]", ran)
+	}
+}
@@ -0,0 +1,155 @@
+// nolint
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"example-grpc-auth/auth"
+	"example-grpc-auth/auth/repo/mongodb/migrations"
+	"example-grpc-auth/auth/repotest"
+	"example-grpc-auth/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Client of MongoDB at MONGO_TEST_URI, local mongod by default.
+// Tests are skipped when it's not reachable.
+func connect(t *testing.T) *mongo.Client {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		uri = "mongodb://localhost:27017"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Skipf("MongoDB at %s: %v", uri, err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		t.Skipf("MongoDB at %s: %v", uri, err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client
+}
+
+// Empty database dropped after the test
+func newTestDB(t *testing.T, client *mongo.Client) *mongo.Database {
+	db := client.Database(fmt.Sprintf("test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() { db.Drop(context.Background()) })
+	return db
+}
+
+// Empty database with migrations applied
+func migratedTestDB(t *testing.T, client *mongo.Client) *mongo.Database {
+	db := newTestDB(t, client)
+	if err := migrations.Run(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestConformance(t *testing.T) {
+	client := connect(t)
+
+	t.Run("UserRepo", func(t *testing.T) {
+		repotest.RunUserRepo(t, func(t *testing.T) auth.UserRepo {
+			return NewUserRepo(migratedTestDB(t, client))
+		})
+	})
+	t.Run("TokenRepo", func(t *testing.T) {
+		repotest.RunTokenRepo(t, func(t *testing.T) auth.TokenRepo {
+			return NewTokenRepo(migratedTestDB(t, client))
+		})
+	})
+	t.Run("RefreshTokenRepo", func(t *testing.T) {
+		repotest.RunRefreshTokenRepo(t, func(t *testing.T) auth.RefreshTokenRepo {
+			return NewRefreshTokenRepo(migratedTestDB(t, client))
+		})
+	})
+}
+
+func TestComparePasswordSpan(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	h, _ := bcrypt.GenerateFromPassword([]byte("pwd"), bcrypt.MinCost)
+	if err := comparePassword(context.Background(), string(h), "pwd"); err != nil {
+		t.Fatalf("comparePassword() error = %v", err)
+	}
+	if err := comparePassword(context.Background(), string(h), "wrong"); err == nil {
+		t.Fatal("comparePassword() of wrong password error = nil")
+	}
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	if s := spans[0]; s.Status().Code != codes.Unset || len(s.Events()) != 0 {
+		t.Errorf("span of match status = %v, events = %d, want unset and none", s.Status(), len(s.Events()))
+	}
+	if s := spans[1]; s.Status().Code != codes.Error || len(s.Events()) != 1 {
+		t.Errorf("span of mismatch status = %v, events = %d, want error recorded", s.Status(), len(s.Events()))
+	}
+}
+
+func TestUsernameKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"alice", "alice", true},
+		{"alice", "ALICE", true},
+		// Decomposed and precomposed é
+		{"jos\u0065\u0301", "jos\u00e9", true},
+		{"JOS\u0045\u0301", "jos\u00e9", true},
+		{"jose", "jos\u00e9", false},
+		{"alice", "alice2", false},
+	}
+	for _, tt := range tests {
+		if got := models.UsernameKey(tt.a) == models.UsernameKey(tt.b); got != tt.same {
+			t.Errorf("UsernameKey(%q) == UsernameKey(%q) is %v, want %v", tt.a, tt.b, got, tt.same)
+		}
+	}
+}
+
+func TestUserRepo_Duplicates(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, connect(t))
+	r := NewUserRepo(db)
+
+	// Accounts created before the unique index
+	_, err := db.Collection(talbleUsers).InsertMany(ctx, []interface{}{
+		bson.M{"username": "alice"},
+		bson.M{"username": "Alice"},
+		bson.M{"username": "bob"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrations.Run(ctx, db); err == nil {
+		t.Error("migrations.Run() with duplicates succeeded, want error")
+	}
+
+	dups, err := r.Duplicates(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dups) != 1 || len(dups["alice"]) != 2 {
+		t.Errorf("Duplicates() = %v, want two alice accounts", dups)
+	}
+}
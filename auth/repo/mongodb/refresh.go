@@ -0,0 +1,122 @@
+package mongodb
+
+import (
+	"context"
+	e "example-grpc-auth/err"
+	"example-grpc-auth/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	refreshTokensT string = "refreshTokens"
+)
+
+type RefreshTokenRepo struct {
+	db *mongo.Database
+}
+
+type refreshTokenDB struct {
+	ID        string    `bson:"_id"`
+	FamilyID  string    `bson:"family_id"`
+	User      user      `bson:"user"`
+	IssuedAt  time.Time `bson:"issued_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+	Used      bool      `bson:"used"`
+	Revoked   bool      `bson:"revoked"`
+}
+
+func NewRefreshTokenRepo(db *mongo.Database) *RefreshTokenRepo {
+	return &RefreshTokenRepo{
+		db: db,
+	}
+}
+
+func (r *RefreshTokenRepo) CreateRefreshToken(c context.Context, t *models.RefreshToken) error {
+	cur := r.db.Collection(refreshTokensT)
+
+	token := &refreshTokenDB{
+		ID:        t.ID,
+		FamilyID:  t.FamilyID,
+		User:      *toDBUser(&t.User),
+		IssuedAt:  t.IssuedAt,
+		ExpiresAt: t.ExpiresAt,
+		Used:      t.Used,
+		Revoked:   t.Revoked,
+	}
+
+	if _, err := cur.InsertOne(c, token); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepo) UseRefreshToken(c context.Context, id string) (*models.RefreshToken, error) {
+	cur := r.db.Collection(refreshTokensT)
+
+	token := new(refreshTokenDB)
+
+	// Return document state before update, so the caller can detect reuse
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err := cur.FindOneAndUpdate(c, bson.M{"_id": id}, bson.M{"$set": bson.M{"used": true}}, opts).Decode(token)
+	if err == mongo.ErrNoDocuments {
+		return nil, e.ErrInvalidRefresh
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toModelsRefreshToken(token), nil
+}
+
+func (r *RefreshTokenRepo) GetRefreshToken(c context.Context, id string) (*models.RefreshToken, error) {
+	cur := r.db.Collection(refreshTokensT)
+
+	token := new(refreshTokenDB)
+	err := cur.FindOne(c, bson.M{"_id": id}).Decode(token)
+	if err == mongo.ErrNoDocuments {
+		return nil, e.ErrInvalidRefresh
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toModelsRefreshToken(token), nil
+}
+
+func toModelsRefreshToken(token *refreshTokenDB) *models.RefreshToken {
+	return &models.RefreshToken{
+		ID:        token.ID,
+		FamilyID:  token.FamilyID,
+		User:      *toModelsUser(&token.User),
+		IssuedAt:  token.IssuedAt,
+		ExpiresAt: token.ExpiresAt,
+		Used:      token.Used,
+		Revoked:   token.Revoked,
+	}
+}
+
+func (r *RefreshTokenRepo) RevokeFamily(c context.Context, familyID string) error {
+	cur := r.db.Collection(refreshTokensT)
+
+	if _, err := cur.UpdateMany(c, bson.M{"family_id": familyID}, bson.M{"$set": bson.M{"revoked": true}}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepo) RevokeUserFamilies(c context.Context, userID string) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		// No tokens are issued to invalid ids
+		return nil
+	}
+	cur := r.db.Collection(refreshTokensT)
+
+	if _, err := cur.UpdateMany(c, bson.M{"user._id": id}, bson.M{"$set": bson.M{"revoked": true}}); err != nil {
+		return err
+	}
+	return nil
+}
@@ -2,25 +2,34 @@ package mongodb
 
 import (
 	"context"
-	"crypto/sha1"
-	"encoding/hex"
-	"log"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
 	rTokensT string = "revokedTokens"
+	rUsersT  string = "revokedUsers"
 )
 
 type TokenRepo struct {
 	db *mongo.Database
 }
 type tokenDB struct {
+	// Token jti
 	ID   string    `bson:"_id"`
 	Date time.Time `bson:"revoketion_date"`
+	// Token expiration time. Document is removed by TTL index after it.
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Tokens of user issued before NotBefore are revoked
+type revokedUserDB struct {
+	ID        string    `bson:"_id"`
+	NotBefore time.Time `bson:"not_before"`
+	UpdatedAt time.Time `bson:"updated_at"`
 }
 
 func NewTokenRepo(db *mongo.Database) *TokenRepo {
@@ -29,42 +38,118 @@ func NewTokenRepo(db *mongo.Database) *TokenRepo {
 	}
 }
 
-func (t TokenRepo) RevokeToken(c context.Context, tokenString string) error {
-	cur := t.db.Collection(rTokensT)
-
-	// Create hash of key string
-	hasher := sha1.New()
-	if _, err := hasher.Write([]byte(tokenString)); err != nil {
-		log.Println(err)
-		return err
+func (t TokenRepo) RevokeToken(c context.Context, jti string, exp time.Time) error {
+	// Expired token can't be used anyway
+	if !exp.After(time.Now()) {
+		return nil
 	}
-	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	cur := t.db.Collection(rTokensT)
 
 	token := tokenDB{
-		ID:   hash,
-		Date: time.Now(),
+		ID:        jti,
+		Date:      time.Now(),
+		ExpiresAt: exp,
 	}
 
 	if _, err := cur.InsertOne(c, token); err != nil {
+		// Already revoked
+		if mongo.IsDuplicateKeyError(err) {
+			return nil
+		}
 		return err
 	}
 	return nil
 }
 
-func (t TokenRepo) IsRevoked(c context.Context, token string) (bool, error) {
+func (t TokenRepo) IsRevoked(c context.Context, jti string) (bool, error) {
 	cur := t.db.Collection(rTokensT)
-	// Create hash of key string
-	hasher := sha1.New()
-	if _, err := hasher.Write([]byte(token)); err != nil {
-		log.Println(err)
-		return true, err
-	}
-	hash := hex.EncodeToString(hasher.Sum(nil))
 
-	res := cur.FindOne(c, bson.M{"_id": hash})
-	if res.Err() == mongo.ErrNoDocuments {
+	err := cur.FindOne(c, bson.M{"_id": jti}).Err()
+	if err == mongo.ErrNoDocuments {
 		return false, nil
 	}
-
+	if err != nil {
+		return false, err
+	}
 	return true, nil
 }
+
+func (t TokenRepo) RevokeUserTokens(c context.Context, userID string, before time.Time) error {
+	cur := t.db.Collection(rUsersT)
+
+	// Never move the marker back
+	_, err := cur.UpdateOne(c,
+		bson.M{"_id": userID},
+		bson.M{
+			"$max": bson.M{"not_before": before},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+func (t TokenRepo) RevokedBefore(c context.Context, userID string) (time.Time, error) {
+	cur := t.db.Collection(rUsersT)
+
+	marker := new(revokedUserDB)
+	err := cur.FindOne(c, bson.M{"_id": userID}).Decode(marker)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return marker.NotBefore, nil
+}
+
+// Ids of unexpired tokens revoked at or after since
+func (t TokenRepo) RevokedTokens(c context.Context, since time.Time) ([]string, error) {
+	cur := t.db.Collection(rTokensT)
+
+	filter := bson.M{
+		"revoketion_date": bson.M{"$gte": since},
+		"expires_at":      bson.M{"$gt": time.Now()},
+	}
+	cursor, err := cur.Find(c, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	var ids []string
+	for cursor.Next(c) {
+		var token tokenDB
+		if err := cursor.Decode(&token); err != nil {
+			return nil, err
+		}
+		ids = append(ids, token.ID)
+	}
+	return ids, cursor.Err()
+}
+
+// User-wide revocation markers changed at or after since
+func (t TokenRepo) RevokedUsers(c context.Context, since time.Time) (map[string]time.Time, error) {
+	cur := t.db.Collection(rUsersT)
+
+	// Markers set before updated_at was introduced have no such field
+	filter := bson.M{}
+	if !since.IsZero() {
+		filter["updated_at"] = bson.M{"$gte": since}
+	}
+	cursor, err := cur.Find(c, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	users := make(map[string]time.Time)
+	for cursor.Next(c) {
+		var marker revokedUserDB
+		if err := cursor.Decode(&marker); err != nil {
+			return nil, err
+		}
+		users[marker.ID] = marker.NotBefore
+	}
+	return users, cursor.Err()
+}
@@ -0,0 +1,39 @@
+package mongodb
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const tracerName = "example-grpc-auth/auth/repo/mongodb"
+
+// Tracer of global provider, as installed at the time of call
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Command monitor starting a span for each Mongo operation, to be set on
+// client options. Commands aren't recorded, they carry password hashes.
+func Monitor() *event.CommandMonitor {
+	return otelmongo.NewMonitor(otelmongo.WithCommandAttributeDisabled(true))
+}
+
+// Check password in its own span, bcrypt dominates sign-in latency.
+// Mismatch is recorded as span error, so failed sign-ins stand out.
+func comparePassword(c context.Context, hash string, p string) error {
+	_, span := tracer().Start(c, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(p))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
@@ -8,22 +8,28 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
 	talbleUsers = "users"
 )
 
+// Usernames are compared ignoring case, but not diacritics,
+// as by the unique index of migrations package
+var usernameCollation = &options.Collation{Locale: "en", Strength: 2}
+
 type UserRepo struct {
 	db *mongo.Database
 }
 
 type user struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty"`
-	MysqlID  int                `bson:"mysql_id,omitempty"`
-	Username string             `bson:"username,omitempty"`
-	Password string             `bson:"password,omitempty"`
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	MysqlID     int                `bson:"mysql_id,omitempty"`
+	Username    string             `bson:"username,omitempty"`
+	Password    string             `bson:"password,omitempty"`
+	Roles       []string           `bson:"roles,omitempty"`
+	Permissions []string           `bson:"permissions,omitempty"`
 }
 
 func NewUserRepo(db *mongo.Database) *UserRepo {
@@ -32,19 +38,52 @@ func NewUserRepo(db *mongo.Database) *UserRepo {
 	}
 }
 
+// Users sharing a username up to case and Unicode normalisation,
+// created before the unique index. Keyed by folded username.
+func (r *UserRepo) Duplicates(c context.Context) (map[string][]*models.User, error) {
+	cur := r.db.Collection(talbleUsers)
+
+	opts := options.Find().SetProjection(bson.M{"username": 1, "mysql_id": 1})
+	res, err := cur.Find(c, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close(c)
+
+	byKey := make(map[string][]*models.User)
+	for res.Next(c) {
+		user := new(user)
+		if err := res.Decode(user); err != nil {
+			return nil, err
+		}
+		key := models.UsernameKey(user.Username)
+		byKey[key] = append(byKey[key], toModelsUser(user))
+	}
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+
+	for key, users := range byKey {
+		if len(users) < 2 {
+			delete(byKey, key)
+		}
+	}
+	return byKey, nil
+}
+
 func (r *UserRepo) CreateUser(c context.Context, u string, p string) error {
 	cur := r.db.Collection(talbleUsers)
 
 	user := &user{
-		Username: u,
+		Username: models.NormUsername(u),
 		Password: p,
 	}
 
 	if _, err := cur.InsertOne(c, user); err != nil {
-
 		if mongo.IsDuplicateKeyError(err) {
 			return e.ErrDupKey
 		}
+		return err
 	}
 
 	return nil
@@ -55,7 +94,8 @@ func (r *UserRepo) GetUser(c context.Context, u string, p string) (*models.User,
 
 	user := new(user)
 
-	err := cur.FindOne(c, bson.M{"username": u}).Decode(user)
+	opts := options.FindOne().SetCollation(usernameCollation)
+	err := cur.FindOne(c, bson.M{"username": models.NormUsername(u)}, opts).Decode(user)
 	if err == mongo.ErrNoDocuments {
 		return nil, e.ErrUserNotFound
 	}
@@ -63,31 +103,41 @@ func (r *UserRepo) GetUser(c context.Context, u string, p string) (*models.User,
 		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(p))
-	if err != nil {
+	if err := comparePassword(c, user.Password, p); err != nil {
 		return nil, e.ErrInvalidCred
 	}
 	return toModelsUser(user), nil
 }
 
-func (r *UserRepo) DeleteUser(c context.Context, u *models.User) error {
-	user := toDBUser(u)
+func (r *UserRepo) DeleteUser(c context.Context, u *models.User) (*models.User, error) {
+	filt, ok := userFilter(u)
+	if !ok {
+		return nil, e.ErrUserNotFound
+	}
 
 	cur := r.db.Collection(talbleUsers)
 
-	res, err := cur.DeleteOne(c, user)
-	if err != nil {
-		return err
+	deleted := new(user)
+	opts := options.FindOneAndDelete().SetCollation(usernameCollation)
+	err := cur.FindOneAndDelete(c, filt, opts).Decode(deleted)
+	if err == mongo.ErrNoDocuments {
+		return nil, e.ErrUserNotFound
 	}
-	if res.DeletedCount == 0 {
-		return e.ErrUserNotFound
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return toModelsUser(deleted), nil
 }
 
 func (r *UserRepo) UpdateUser(c context.Context, filt *models.User, upd *models.User) (*models.User, error) {
-	filtDB := toDBUser(filt)
+	filtDB, ok := userFilter(filt)
+	if !ok {
+		return nil, e.ErrUserNotFound
+	}
 	updDB := toDBUser(upd)
+	// Update must not move the user to another id
+	updDB.ID = primitive.NilObjectID
+	updDB.Username = models.NormUsername(updDB.Username)
 
 	update := bson.M{
 		"$set": updDB,
@@ -95,39 +145,116 @@ func (r *UserRepo) UpdateUser(c context.Context, filt *models.User, upd *models.
 
 	cur := r.db.Collection(talbleUsers)
 
-	res, err := cur.UpdateOne(c, filtDB, update)
+	user := new(user)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetCollation(usernameCollation)
+	err := cur.FindOneAndUpdate(c, filtDB, update, opts).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return nil, e.ErrUserNotFound
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, e.ErrDupKey
+	}
 	if err != nil {
 		return nil, err
 	}
-	if res.MatchedCount == 0 {
+
+	return toModelsUser(user), nil
+}
+
+func (r *UserRepo) GetUserByID(c context.Context, id string) (*models.User, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
 		return nil, e.ErrUserNotFound
 	}
 
+	cur := r.db.Collection(talbleUsers)
+
 	user := new(user)
-	err = cur.FindOne(c, bson.M{"_id": filtDB.ID}).Decode(user)
+	err = cur.FindOne(c, bson.M{"_id": oid}).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return nil, e.ErrUserNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
+	return toModelsUser(user), nil
+}
+
+func (r *UserRepo) AddRole(c context.Context, id string, role string) (*models.User, error) {
+	return r.updateRoles(c, id, bson.M{"$addToSet": bson.M{"roles": role}})
+}
+
+func (r *UserRepo) RemoveRole(c context.Context, id string, role string) (*models.User, error) {
+	return r.updateRoles(c, id, bson.M{"$pull": bson.M{"roles": role}})
+}
+
+func (r *UserRepo) AddPermission(c context.Context, id string, perm string) (*models.User, error) {
+	return r.updateRoles(c, id, bson.M{"$addToSet": bson.M{"permissions": perm}})
+}
+
+func (r *UserRepo) RemovePermission(c context.Context, id string, perm string) (*models.User, error) {
+	return r.updateRoles(c, id, bson.M{"$pull": bson.M{"permissions": perm}})
+}
+
+func (r *UserRepo) updateRoles(c context.Context, id string, update bson.M) (*models.User, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, e.ErrUserNotFound
+	}
 
+	cur := r.db.Collection(talbleUsers)
+
+	user := new(user)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = cur.FindOneAndUpdate(c, bson.M{"_id": oid}, update, opts).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return nil, e.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
 	return toModelsUser(user), nil
+}
 
+// Filter on non-zero id, mysql_id and username.
+// False if it can't match anyone: empty filter would match any user.
+func userFilter(u *models.User) (bson.M, bool) {
+	filt := bson.M{}
+	if u.ID != "" {
+		oid, err := primitive.ObjectIDFromHex(u.ID)
+		if err != nil {
+			return nil, false
+		}
+		filt["_id"] = oid
+	}
+	if u.MysqlID != 0 {
+		filt["mysql_id"] = u.MysqlID
+	}
+	if u.Username != "" {
+		filt["username"] = models.NormUsername(u.Username)
+	}
+	return filt, len(filt) > 0
 }
 
 func toDBUser(u *models.User) *user {
 	id, _ := primitive.ObjectIDFromHex(u.ID)
 	return &user{
-		ID:       id,
-		MysqlID:  u.MysqlID,
-		Username: u.Username,
-		Password: u.Password,
+		ID:          id,
+		MysqlID:     u.MysqlID,
+		Username:    u.Username,
+		Password:    u.Password,
+		Roles:       u.Roles,
+		Permissions: u.Permissions,
 	}
 }
 
 func toModelsUser(u *user) *models.User {
 	return &models.User{
-		ID:       u.ID.Hex(),
-		MysqlID:  u.MysqlID,
-		Username: u.Username,
-		Password: u.Password,
+		ID:          u.ID.Hex(),
+		MysqlID:     u.MysqlID,
+		Username:    u.Username,
+		Password:    u.Password,
+		Roles:       u.Roles,
+		Permissions: u.Permissions,
 	}
 }
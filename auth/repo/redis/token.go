@@ -0,0 +1,105 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	rTokenKey = "revoked:token:"
+	rUserKey  = "revoked:user:"
+	// Default refresh token lifetime
+	defaultUserTTL = 30 * 24 * time.Hour
+)
+
+// Moves user marker forward only, expiring in ARGV[2] ms.
+// Markers set before they expired get the expiration too.
+var setMax = goredis.NewScript(`
+local cur = redis.call("GET", KEYS[1])
+if not cur or tonumber(cur) < tonumber(ARGV[1]) then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+elseif redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Revoked tokens storage. Every revoked token is a key expiring along with the token,
+// user markers expire once no token issued before them can be valid.
+type TokenRepo struct {
+	rdb goredis.UniversalClient
+	// Marker lifetime in ns, see SetUserTTL
+	userTTL atomic.Int64
+}
+
+// userTTL is the longest token lifetime, refresh tokens included.
+// Zero means default refresh token lifetime.
+func NewTokenRepo(rdb goredis.UniversalClient, userTTL time.Duration) *TokenRepo {
+	if userTTL == 0 {
+		userTTL = defaultUserTTL
+	}
+	t := &TokenRepo{rdb: rdb}
+	t.userTTL.Store(int64(userTTL))
+	return t
+}
+
+// Extend user marker lifetime for refresh tokens issued under a longer
+// lifetime after config reload. Never shortened, tokens issued before the
+// reload stay valid as long as they were issued for. Zero means default.
+func (t *TokenRepo) SetUserTTL(userTTL time.Duration) {
+	if userTTL == 0 {
+		userTTL = defaultUserTTL
+	}
+	for {
+		cur := t.userTTL.Load()
+		if int64(userTTL) <= cur || t.userTTL.CompareAndSwap(cur, int64(userTTL)) {
+			return
+		}
+	}
+}
+
+func (t *TokenRepo) RevokeToken(c context.Context, jti string, exp time.Time) error {
+	ttl := time.Until(exp)
+	// Expired token can't be used anyway
+	if ttl <= 0 {
+		return nil
+	}
+	return t.rdb.Set(c, rTokenKey+jti, time.Now().Unix(), ttl).Err()
+}
+
+func (t *TokenRepo) IsRevoked(c context.Context, jti string) (bool, error) {
+	n, err := t.rdb.Exists(c, rTokenKey+jti).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (t *TokenRepo) RevokeUserTokens(c context.Context, userID string, before time.Time) error {
+	ttl := time.Until(before.Add(time.Duration(t.userTTL.Load())))
+	// Tokens issued before have expired anyway
+	if ttl <= 0 {
+		return nil
+	}
+	return setMax.Run(c, t.rdb, []string{rUserKey + userID}, before.UnixNano(), ttl.Milliseconds()).Err()
+}
+
+func (t *TokenRepo) RevokedBefore(c context.Context, userID string) (time.Time, error) {
+	v, err := t.rdb.Get(c, rUserKey+userID).Result()
+	if errors.Is(err, goredis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, n), nil
+}
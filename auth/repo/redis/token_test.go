@@ -0,0 +1,161 @@
+// nolint
+package redis
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"example-grpc-auth/auth"
+	"example-grpc-auth/auth/repotest"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+func newTestRepo(t *testing.T) (*TokenRepo, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	rdb := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return NewTokenRepo(rdb, 0), mr
+}
+
+func TestTokenRepo_RevokeToken(t *testing.T) {
+	r, mr := newTestRepo(t)
+	ctx := context.Background()
+
+	if err := r.RevokeToken(ctx, "jti-1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := r.IsRevoked(ctx, "jti-1"); err != nil || !ok {
+		t.Errorf("IsRevoked() = %v, %v, want true", ok, err)
+	}
+	if ok, err := r.IsRevoked(ctx, "jti-2"); err != nil || ok {
+		t.Errorf("IsRevoked() = %v, %v for unknown token, want false", ok, err)
+	}
+
+	// Key lives until token expiration
+	if ttl := mr.TTL(rTokenKey + "jti-1"); ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("TTL = %v, want about 1h", ttl)
+	}
+	mr.FastForward(time.Hour)
+	if ok, _ := r.IsRevoked(ctx, "jti-1"); ok {
+		t.Error("IsRevoked() = true after token expiration, want false")
+	}
+}
+
+func TestTokenRepo_RevokeExpiredToken(t *testing.T) {
+	r, mr := newTestRepo(t)
+
+	if err := r.RevokeToken(context.Background(), "jti-1", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(rTokenKey + "jti-1") {
+		t.Error("expired token stored")
+	}
+}
+
+func TestTokenRepo_RevokeUserTokens(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+
+	if before, err := r.RevokedBefore(ctx, "1"); err != nil || !before.IsZero() {
+		t.Errorf("RevokedBefore() = %v, %v, want zero time", before, err)
+	}
+
+	now := time.Now()
+	if err := r.RevokeUserTokens(ctx, "1", now); err != nil {
+		t.Fatal(err)
+	}
+	// Marker never moves back
+	if err := r.RevokeUserTokens(ctx, "1", now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if before, err := r.RevokedBefore(ctx, "1"); err != nil || !before.Equal(now) {
+		t.Errorf("RevokedBefore() = %v, %v, want %v", before, err, now)
+	}
+
+	later := now.Add(time.Minute)
+	if err := r.RevokeUserTokens(ctx, "1", later); err != nil {
+		t.Fatal(err)
+	}
+	if before, _ := r.RevokedBefore(ctx, "1"); !before.Equal(later) {
+		t.Errorf("RevokedBefore() = %v, want %v", before, later)
+	}
+}
+
+func TestTokenRepo_RevokeUserTokensExpiry(t *testing.T) {
+	r, mr := newTestRepo(t)
+	r.userTTL.Store(int64(time.Hour))
+	ctx := context.Background()
+
+	// Marker lives until tokens issued before it expire
+	if err := r.RevokeUserTokens(ctx, "1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(rUserKey + "1"); ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("TTL = %v, want about 1h", ttl)
+	}
+	mr.FastForward(time.Hour)
+	if mr.Exists(rUserKey + "1") {
+		t.Error("marker kept after tokens issued before it expired")
+	}
+
+	// Marker without expiration, set before markers expired
+	legacy := time.Now().Add(-time.Minute)
+	mr.Set(rUserKey+"2", strconv.FormatInt(legacy.UnixNano(), 10))
+	if err := r.RevokeUserTokens(ctx, "2", legacy.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(rUserKey + "2"); ttl <= 0 {
+		t.Errorf("legacy marker TTL = %v, want expiration", ttl)
+	}
+
+	if err := r.RevokeUserTokens(ctx, "3", time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(rUserKey + "3") {
+		t.Error("marker stored after tokens issued before it expired")
+	}
+}
+
+func TestTokenRepo_Unavailable(t *testing.T) {
+	r, mr := newTestRepo(t)
+	mr.Close()
+
+	if _, err := r.IsRevoked(context.Background(), "jti-1"); err == nil {
+		t.Error("IsRevoked() error = nil with Redis down")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	repotest.RunTokenRepo(t, func(t *testing.T) auth.TokenRepo {
+		r, _ := newTestRepo(t)
+		return r
+	})
+}
+
+func TestTokenRepo_SetUserTTL(t *testing.T) {
+	r, mr := newTestRepo(t)
+	r.userTTL.Store(int64(time.Hour))
+	ctx := context.Background()
+
+	// Longer refresh lifetime after reload extends new markers
+	r.SetUserTTL(2 * time.Hour)
+	if err := r.RevokeUserTokens(ctx, "1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(rUserKey + "1"); ttl <= 119*time.Minute || ttl > 2*time.Hour {
+		t.Errorf("TTL = %v, want about 2h", ttl)
+	}
+
+	// Shorter one keeps covering tokens issued before reload
+	r.SetUserTTL(time.Minute)
+	if err := r.RevokeUserTokens(ctx, "2", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(rUserKey + "2"); ttl <= 119*time.Minute {
+		t.Errorf("TTL = %v after shorter lifetime, want about 2h", ttl)
+	}
+}
@@ -0,0 +1,220 @@
+// Package sql implements auth repositories over database/sql.
+// PostgreSQL ("pgx" driver), MySQL ("mysql") and SQLite ("sqlite") are supported,
+// the driver itself must be registered by the caller.
+package sql
+
+import (
+	"context"
+	stdsql "database/sql"
+	"embed"
+	e "example-grpc-auth/err"
+	"example-grpc-auth/models"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrations embed.FS
+
+// Database handle with SQL dialect of its driver
+type DB struct {
+	*stdsql.DB
+	dialect string
+}
+
+// Open database and apply pending migrations
+func Open(c context.Context, driver string, dsn string) (*DB, error) {
+	dialect, err := dialectOf(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := stdsql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	d := &DB{DB: db, dialect: dialect}
+	// SQLite has a single writer, concurrent writes on other connections fail with SQLITE_BUSY
+	if dialect == "sqlite" {
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := d.Migrate(c); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func dialectOf(driver string) (string, error) {
+	switch driver {
+	case "pgx", "postgres":
+		return "postgres", nil
+	case "mysql":
+		return "mysql", nil
+	case "sqlite", "sqlite3":
+		return "sqlite", nil
+	}
+	return "", fmt.Errorf("unsupported SQL driver %q", driver)
+}
+
+// Apply embedded migrations not applied yet, in file name order.
+// Applied versions are recorded in schema_migrations table.
+func (d *DB) Migrate(c context.Context) error {
+	_, err := d.ExecContext(c, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	applied := make(map[int64]bool)
+	rows, err := d.QueryContext(c, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var v int64
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	dir := path.Join("migrations", d.dialect)
+	files, err := fs.ReadDir(migrations, dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	for _, f := range files {
+		// File name starts with version, e.g. 0001_init.sql
+		version, err := strconv.ParseInt(strings.SplitN(f.Name(), "_", 2)[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("migration %s: invalid version: %w", f.Name(), err)
+		}
+		if applied[version] {
+			continue
+		}
+
+		script, err := migrations.ReadFile(path.Join(dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		if err := d.migrate(c, version, string(script)); err != nil {
+			return fmt.Errorf("migration %s: %w", f.Name(), err)
+		}
+	}
+	return d.backfillUsernameKeys(c)
+}
+
+// Username keys of users created before the column, computed here as SQL
+// case folding differs between databases. Fails while usernames equal up to
+// case exist, they must be renamed first.
+func (d *DB) backfillUsernameKeys(c context.Context) error {
+	rows, err := d.QueryContext(c, "SELECT id, username FROM users WHERE username_key IS NULL")
+	if err != nil {
+		return err
+	}
+	users := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var username string
+		if err := rows.Scan(&id, &username); err != nil {
+			rows.Close()
+			return err
+		}
+		users[id] = username
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, username := range users {
+		_, err := d.ExecContext(c, d.rebind("UPDATE users SET username_key = ? WHERE id = ?"), models.UsernameKey(username), id)
+		if isDupKey(err) {
+			return fmt.Errorf("username %q of user %d: %w, rename duplicate accounts", username, id, e.ErrDupKey)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DB) migrate(c context.Context, version int64, script string) error {
+	tx, err := d.BeginTx(c, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Not every driver accepts multiple statements in one call
+	for _, stmt := range strings.Split(script, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(c, stmt); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(c, d.rebind("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)"),
+		version, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete revoked and refresh tokens past their expiration, SQL has no TTL indexes
+func (d *DB) PurgeExpired(c context.Context) error {
+	now := time.Now().UnixNano()
+	if _, err := d.ExecContext(c, d.rebind("DELETE FROM revoked_tokens WHERE expires_at < ?"), now); err != nil {
+		return err
+	}
+	_, err := d.ExecContext(c, d.rebind("DELETE FROM refresh_tokens WHERE expires_at < ?"), now)
+	return err
+}
+
+// Replace "?" placeholders with "$n" for PostgreSQL
+func (d *DB) rebind(query string) string {
+	if d.dialect != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Unique constraint violation. Checked by message to stay driver agnostic.
+func isDupKey(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "UNIQUE constraint failed") || // SQLite
+		strings.Contains(msg, "SQLSTATE 23505") || // PostgreSQL
+		strings.Contains(msg, "duplicate key value") ||
+		strings.Contains(msg, "Error 1062") // MySQL
+}
@@ -0,0 +1,46 @@
+CREATE TABLE users (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	mysql_id BIGINT NOT NULL DEFAULT 0,
+	username VARCHAR(255) NOT NULL UNIQUE,
+	password VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE user_roles (
+	user_id BIGINT NOT NULL,
+	role VARCHAR(255) NOT NULL,
+	PRIMARY KEY (user_id, role)
+);
+
+CREATE TABLE user_permissions (
+	user_id BIGINT NOT NULL,
+	permission VARCHAR(255) NOT NULL,
+	PRIMARY KEY (user_id, permission)
+);
+
+CREATE TABLE revoked_tokens (
+	jti VARCHAR(255) PRIMARY KEY,
+	revoked_at BIGINT NOT NULL,
+	expires_at BIGINT NOT NULL
+);
+
+CREATE INDEX revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE revoked_users (
+	user_id VARCHAR(255) PRIMARY KEY,
+	not_before BIGINT NOT NULL
+);
+
+CREATE TABLE refresh_tokens (
+	id VARCHAR(64) PRIMARY KEY,
+	family_id VARCHAR(64) NOT NULL,
+	user_id VARCHAR(255) NOT NULL,
+	username VARCHAR(255) NOT NULL,
+	mysql_id BIGINT NOT NULL,
+	issued_at BIGINT NOT NULL,
+	expires_at BIGINT NOT NULL,
+	used BOOLEAN NOT NULL,
+	revoked BOOLEAN NOT NULL
+);
+
+CREATE INDEX refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_expires_at ON refresh_tokens (expires_at);
@@ -0,0 +1,5 @@
+-- Username up to case and Unicode normalization, filled in by Migrate.
+-- Binary collation, so diacritics stay significant.
+ALTER TABLE users ADD COLUMN username_key VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;
+
+CREATE UNIQUE INDEX users_username_key ON users (username_key);
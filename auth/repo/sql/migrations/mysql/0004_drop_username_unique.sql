@@ -0,0 +1,3 @@
+-- Unique index of 0001 follows the accent-insensitive column collation and
+-- rejects names users_username_key tells apart, e.g. "jose" and "josé".
+ALTER TABLE users DROP INDEX username;
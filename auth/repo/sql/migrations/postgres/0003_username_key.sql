@@ -0,0 +1,8 @@
+-- Username up to case and Unicode normalization, filled in by Migrate.
+-- Constraint of 0001 is implicitly named users_username_key, it makes way
+-- for the index below.
+ALTER TABLE users DROP CONSTRAINT users_username_key;
+
+ALTER TABLE users ADD COLUMN username_key VARCHAR(255);
+
+CREATE UNIQUE INDEX users_username_key ON users (username_key);
@@ -0,0 +1,4 @@
+-- Username up to case and Unicode normalization, filled in by Migrate
+ALTER TABLE users ADD COLUMN username_key VARCHAR(255);
+
+CREATE UNIQUE INDEX users_username_key ON users (username_key);
@@ -0,0 +1,79 @@
+package sql
+
+import (
+	"context"
+	stdsql "database/sql"
+	e "example-grpc-auth/err"
+	"example-grpc-auth/models"
+	"time"
+)
+
+type RefreshTokenRepo struct {
+	db *DB
+}
+
+func NewRefreshTokenRepo(db *DB) *RefreshTokenRepo {
+	return &RefreshTokenRepo{
+		db: db,
+	}
+}
+
+func (r *RefreshTokenRepo) CreateRefreshToken(c context.Context, t *models.RefreshToken) error {
+	_, err := r.db.ExecContext(c, r.db.rebind(`INSERT INTO refresh_tokens
+		(id, family_id, user_id, username, mysql_id, issued_at, expires_at, used, revoked)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		t.ID, t.FamilyID, t.User.ID, t.User.Username, t.User.MysqlID,
+		t.IssuedAt.UnixNano(), t.ExpiresAt.UnixNano(), t.Used, t.Revoked)
+	return err
+}
+
+func (r *RefreshTokenRepo) UseRefreshToken(c context.Context, id string) (*models.RefreshToken, error) {
+	// Only one caller flips the flag, so the token was unused before for it alone
+	res, err := r.db.ExecContext(c, r.db.rebind("UPDATE refresh_tokens SET used = ? WHERE id = ? AND used = ?"), true, id, false)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := r.GetRefreshToken(c, id)
+	if err != nil {
+		return nil, err
+	}
+	token.Used = n == 0
+
+	return token, nil
+}
+
+func (r *RefreshTokenRepo) GetRefreshToken(c context.Context, id string) (*models.RefreshToken, error) {
+	var (
+		token               models.RefreshToken
+		issuedAt, expiresAt int64
+	)
+	err := r.db.QueryRowContext(c, r.db.rebind(`SELECT id, family_id, user_id, username, mysql_id, issued_at, expires_at, used, revoked
+		FROM refresh_tokens WHERE id = ?`), id).Scan(
+		&token.ID, &token.FamilyID, &token.User.ID, &token.User.Username, &token.User.MysqlID,
+		&issuedAt, &expiresAt, &token.Used, &token.Revoked)
+	if err == stdsql.ErrNoRows {
+		return nil, e.ErrInvalidRefresh
+	}
+	if err != nil {
+		return nil, err
+	}
+	token.IssuedAt = time.Unix(0, issuedAt)
+	token.ExpiresAt = time.Unix(0, expiresAt)
+
+	return &token, nil
+}
+
+func (r *RefreshTokenRepo) RevokeFamily(c context.Context, familyID string) error {
+	_, err := r.db.ExecContext(c, r.db.rebind("UPDATE refresh_tokens SET revoked = ? WHERE family_id = ?"), true, familyID)
+	return err
+}
+
+func (r *RefreshTokenRepo) RevokeUserFamilies(c context.Context, userID string) error {
+	_, err := r.db.ExecContext(c, r.db.rebind("UPDATE refresh_tokens SET revoked = ? WHERE user_id = ?"), true, userID)
+	return err
+}
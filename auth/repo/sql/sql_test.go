@@ -0,0 +1,252 @@
+// nolint
+package sql
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"example-grpc-auth/auth"
+	"example-grpc-auth/auth/repotest"
+	e "example-grpc-auth/err"
+	"example-grpc-auth/models"
+
+	"golang.org/x/crypto/bcrypt"
+	_ "modernc.org/sqlite"
+)
+
+func openTestDB(t *testing.T) *DB {
+	db, err := Open(context.Background(), "sqlite", filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func createTestUser(t *testing.T, r *UserRepo, username string) *models.User {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pwd"), bcrypt.MinCost)
+	if err := r.CreateUser(context.Background(), username, string(hash)); err != nil {
+		t.Fatal(err)
+	}
+	u, err := r.GetUser(context.Background(), username, "pwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestMigrate(t *testing.T) {
+	db := openTestDB(t)
+	// Applied migrations are skipped
+	if err := db.Migrate(context.Background()); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	files, err := fs.ReadDir(migrations, "migrations/"+db.dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(files) {
+		t.Errorf("schema_migrations has %d rows, want %d", n, len(files))
+	}
+}
+
+func TestMigrate_UsernameKeys(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	r := NewUserRepo(db)
+
+	// Users created before username_key
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pwd"), bcrypt.MinCost)
+	if _, err := db.Exec("INSERT INTO users (username, password) VALUES (?, ?)", "Alice", string(hash)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	if u, err := r.GetUser(ctx, "alice", "pwd"); err != nil || u.Username != "Alice" {
+		t.Errorf("GetUser() after backfill = %+v, %v", u, err)
+	}
+
+	if _, err := db.Exec("INSERT INTO users (username, password) VALUES (?, ?)", "ALICE", string(hash)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(ctx); !errors.Is(err, e.ErrDupKey) {
+		t.Errorf("Migrate() with duplicate usernames error = %v, want %v", err, e.ErrDupKey)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	d := &DB{dialect: "postgres"}
+	if got := d.rebind("a = ? AND b = ?"); got != "a = $1 AND b = $2" {
+		t.Errorf("rebind() = %q", got)
+	}
+	d = &DB{dialect: "mysql"}
+	if got := d.rebind("a = ?"); got != "a = ?" {
+		t.Errorf("rebind() = %q", got)
+	}
+}
+
+func TestUserRepo(t *testing.T) {
+	ctx := context.Background()
+	r := NewUserRepo(openTestDB(t))
+
+	u := createTestUser(t, r, "test")
+	if u.ID == "" || u.Username != "test" {
+		t.Fatalf("GetUser() = %+v", u)
+	}
+
+	if err := r.CreateUser(ctx, "test", "hash"); !errors.Is(err, e.ErrDupKey) {
+		t.Errorf("CreateUser() duplicate error = %v, want ErrDupKey", err)
+	}
+	if _, err := r.GetUser(ctx, "test", "wrong"); !errors.Is(err, e.ErrInvalidCred) {
+		t.Errorf("GetUser() wrong password error = %v, want ErrInvalidCred", err)
+	}
+	if _, err := r.GetUser(ctx, "nobody", "pwd"); !errors.Is(err, e.ErrUserNotFound) {
+		t.Errorf("GetUser() unknown user error = %v, want ErrUserNotFound", err)
+	}
+	if _, err := r.GetUserByID(ctx, "not-a-number"); !errors.Is(err, e.ErrUserNotFound) {
+		t.Errorf("GetUserByID() invalid id error = %v, want ErrUserNotFound", err)
+	}
+
+	// Update by username, zero fields kept
+	got, err := r.UpdateUser(ctx, &models.User{Username: "test"}, &models.User{MysqlID: 7, Permissions: []string{"orders:read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != u.ID || got.Username != "test" || got.MysqlID != 7 || !reflect.DeepEqual(got.Permissions, []string{"orders:read"}) {
+		t.Errorf("UpdateUser() = %+v", got)
+	}
+
+	createTestUser(t, r, "other")
+	if _, err := r.UpdateUser(ctx, &models.User{ID: u.ID}, &models.User{Username: "other"}); !errors.Is(err, e.ErrDupKey) {
+		t.Errorf("UpdateUser() to taken username error = %v, want ErrDupKey", err)
+	}
+	if _, err := r.UpdateUser(ctx, &models.User{}, &models.User{MysqlID: 1}); !errors.Is(err, e.ErrUserNotFound) {
+		t.Errorf("UpdateUser() empty filter error = %v, want ErrUserNotFound", err)
+	}
+
+	// Roles
+	if got, err = r.AddRole(ctx, u.ID, "admin"); err != nil || !got.HasRole("admin") {
+		t.Errorf("AddRole() = %+v, %v", got, err)
+	}
+	if got, err = r.AddRole(ctx, u.ID, "admin"); err != nil || len(got.Roles) != 1 {
+		t.Errorf("AddRole() twice = %+v, %v", got, err)
+	}
+	if got, err = r.RemoveRole(ctx, u.ID, "admin"); err != nil || got.HasRole("admin") {
+		t.Errorf("RemoveRole() = %+v, %v", got, err)
+	}
+	if _, err := r.AddRole(ctx, "999", "admin"); !errors.Is(err, e.ErrUserNotFound) {
+		t.Errorf("AddRole() unknown user error = %v, want ErrUserNotFound", err)
+	}
+
+	// Delete
+	if _, err := r.DeleteUser(ctx, &models.User{ID: u.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.DeleteUser(ctx, &models.User{ID: u.ID}); !errors.Is(err, e.ErrUserNotFound) {
+		t.Errorf("DeleteUser() twice error = %v, want ErrUserNotFound", err)
+	}
+	if _, err := r.GetUserByID(ctx, u.ID); !errors.Is(err, e.ErrUserNotFound) {
+		t.Errorf("GetUserByID() deleted user error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestTokenRepo(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	r := NewTokenRepo(db)
+
+	if err := r.RevokeToken(ctx, "jti-1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// Revoking twice is fine
+	if err := r.RevokeToken(ctx, "jti-1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RevokeToken(ctx, "jti-expired", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for jti, want := range map[string]bool{"jti-1": true, "jti-2": false, "jti-expired": false} {
+		if ok, err := r.IsRevoked(ctx, jti); err != nil || ok != want {
+			t.Errorf("IsRevoked(%s) = %v, %v, want %v", jti, ok, err, want)
+		}
+	}
+
+	now := time.Now()
+	if err := r.RevokeUserTokens(ctx, "1", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RevokeUserTokens(ctx, "1", now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if before, err := r.RevokedBefore(ctx, "1"); err != nil || !before.Equal(now) {
+		t.Errorf("RevokedBefore() = %v, %v, want %v", before, err, now)
+	}
+	if before, err := r.RevokedBefore(ctx, "2"); err != nil || !before.IsZero() {
+		t.Errorf("RevokedBefore() = %v, %v, want zero time", before, err)
+	}
+
+	if err := db.PurgeExpired(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshTokenRepo(t *testing.T) {
+	ctx := context.Background()
+	r := NewRefreshTokenRepo(openTestDB(t))
+
+	token := &models.RefreshToken{
+		ID:        "hash-1",
+		FamilyID:  "family-1",
+		User:      models.User{ID: "1", Username: "test"},
+		IssuedAt:  time.Unix(100, 0),
+		ExpiresAt: time.Unix(200, 0),
+	}
+	if err := r.CreateRefreshToken(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.UseRefreshToken(ctx, "hash-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, token) {
+		t.Errorf("UseRefreshToken() = %+v, want %+v", got, token)
+	}
+	// Second use reports token as used
+	if got, err = r.UseRefreshToken(ctx, "hash-1"); err != nil || !got.Used {
+		t.Errorf("UseRefreshToken() twice = %+v, %v, want used", got, err)
+	}
+
+	if err := r.RevokeFamily(ctx, "family-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = r.UseRefreshToken(ctx, "hash-1"); !got.Revoked {
+		t.Errorf("UseRefreshToken() after RevokeFamily = %+v, want revoked", got)
+	}
+
+	if _, err := r.UseRefreshToken(ctx, "unknown"); !errors.Is(err, e.ErrInvalidRefresh) {
+		t.Errorf("UseRefreshToken() unknown error = %v, want ErrInvalidRefresh", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	t.Run("UserRepo", func(t *testing.T) {
+		repotest.RunUserRepo(t, func(t *testing.T) auth.UserRepo { return NewUserRepo(openTestDB(t)) })
+	})
+	t.Run("TokenRepo", func(t *testing.T) {
+		repotest.RunTokenRepo(t, func(t *testing.T) auth.TokenRepo { return NewTokenRepo(openTestDB(t)) })
+	})
+	t.Run("RefreshTokenRepo", func(t *testing.T) {
+		repotest.RunRefreshTokenRepo(t, func(t *testing.T) auth.RefreshTokenRepo { return NewRefreshTokenRepo(openTestDB(t)) })
+	})
+}
@@ -0,0 +1,74 @@
+package sql
+
+import (
+	"context"
+	stdsql "database/sql"
+	"time"
+)
+
+// Revoked tokens storage. Times are stored as Unix nanoseconds.
+type TokenRepo struct {
+	db *DB
+}
+
+func NewTokenRepo(db *DB) *TokenRepo {
+	return &TokenRepo{
+		db: db,
+	}
+}
+
+func (t *TokenRepo) RevokeToken(c context.Context, jti string, exp time.Time) error {
+	// Expired token can't be used anyway
+	if !exp.After(time.Now()) {
+		return nil
+	}
+
+	_, err := t.db.ExecContext(c, t.db.rebind("INSERT INTO revoked_tokens (jti, revoked_at, expires_at) VALUES (?, ?, ?)"),
+		jti, time.Now().UnixNano(), exp.UnixNano())
+	// Already revoked
+	if isDupKey(err) {
+		return nil
+	}
+	return err
+}
+
+func (t *TokenRepo) IsRevoked(c context.Context, jti string) (bool, error) {
+	var n int
+	err := t.db.QueryRowContext(c, t.db.rebind("SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?"), jti).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (t *TokenRepo) RevokeUserTokens(c context.Context, userID string, before time.Time) error {
+	// Never move the marker back
+	update := t.db.rebind("UPDATE revoked_users SET not_before = ? WHERE user_id = ? AND not_before < ?")
+	res, err := t.db.ExecContext(c, update, before.UnixNano(), userID, before.UnixNano())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	_, err = t.db.ExecContext(c, t.db.rebind("INSERT INTO revoked_users (user_id, not_before) VALUES (?, ?)"),
+		userID, before.UnixNano())
+	if isDupKey(err) {
+		// Marker exists, either later than before or inserted concurrently
+		_, err = t.db.ExecContext(c, update, before.UnixNano(), userID, before.UnixNano())
+	}
+	return err
+}
+
+func (t *TokenRepo) RevokedBefore(c context.Context, userID string) (time.Time, error) {
+	var notBefore int64
+	err := t.db.QueryRowContext(c, t.db.rebind("SELECT not_before FROM revoked_users WHERE user_id = ?"), userID).Scan(&notBefore)
+	if err == stdsql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, notBefore), nil
+}
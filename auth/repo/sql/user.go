@@ -0,0 +1,288 @@
+package sql
+
+import (
+	"context"
+	stdsql "database/sql"
+	e "example-grpc-auth/err"
+	"example-grpc-auth/models"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type UserRepo struct {
+	db *DB
+}
+
+func NewUserRepo(db *DB) *UserRepo {
+	return &UserRepo{
+		db: db,
+	}
+}
+
+// Query runner, either *sql.DB or *sql.Tx
+type querier interface {
+	ExecContext(c context.Context, query string, args ...interface{}) (stdsql.Result, error)
+	QueryContext(c context.Context, query string, args ...interface{}) (*stdsql.Rows, error)
+	QueryRowContext(c context.Context, query string, args ...interface{}) *stdsql.Row
+}
+
+func (r *UserRepo) CreateUser(c context.Context, u string, p string) error {
+	query := "INSERT INTO users (username, username_key, password) VALUES (?, ?, ?)"
+	_, err := r.db.ExecContext(c, r.db.rebind(query), models.NormUsername(u), models.UsernameKey(u), p)
+	if isDupKey(err) {
+		return e.ErrDupKey
+	}
+	return err
+}
+
+func (r *UserRepo) GetUser(c context.Context, u string, p string) (*models.User, error) {
+	user, err := r.load(c, r.db, "username_key = ?", models.UsernameKey(u))
+	if err != nil {
+		return nil, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(p))
+	if err != nil {
+		return nil, e.ErrInvalidCred
+	}
+	return user, nil
+}
+
+func (r *UserRepo) GetUserByID(c context.Context, id string) (*models.User, error) {
+	uid, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, e.ErrUserNotFound
+	}
+	return r.load(c, r.db, "id = ?", uid)
+}
+
+func (r *UserRepo) DeleteUser(c context.Context, u *models.User) (*models.User, error) {
+	tx, err := r.db.BeginTx(c, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	id, err := r.find(c, tx, u)
+	if err != nil {
+		return nil, err
+	}
+	user, err := r.load(c, tx, "id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range []string{
+		"DELETE FROM user_roles WHERE user_id = ?",
+		"DELETE FROM user_permissions WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	} {
+		if _, err := tx.ExecContext(c, r.db.rebind(q), id); err != nil {
+			return nil, err
+		}
+	}
+	return user, tx.Commit()
+}
+
+func (r *UserRepo) UpdateUser(c context.Context, filt *models.User, upd *models.User) (*models.User, error) {
+	tx, err := r.db.BeginTx(c, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	id, err := r.find(c, tx, filt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Zero fields are left as is
+	var set []string
+	var args []interface{}
+	if upd.MysqlID != 0 {
+		set = append(set, "mysql_id = ?")
+		args = append(args, upd.MysqlID)
+	}
+	if upd.Username != "" {
+		set = append(set, "username = ?", "username_key = ?")
+		args = append(args, models.NormUsername(upd.Username), models.UsernameKey(upd.Username))
+	}
+	if upd.Password != "" {
+		set = append(set, "password = ?")
+		args = append(args, upd.Password)
+	}
+	if len(set) > 0 {
+		q := "UPDATE users SET " + strings.Join(set, ", ") + " WHERE id = ?"
+		if _, err := tx.ExecContext(c, r.db.rebind(q), append(args, id)...); err != nil {
+			if isDupKey(err) {
+				return nil, e.ErrDupKey
+			}
+			return nil, err
+		}
+	}
+	if len(upd.Roles) > 0 {
+		if err := r.replace(c, tx, "user_roles", "role", id, upd.Roles); err != nil {
+			return nil, err
+		}
+	}
+	if len(upd.Permissions) > 0 {
+		if err := r.replace(c, tx, "user_permissions", "permission", id, upd.Permissions); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := r.load(c, tx, "id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	return user, tx.Commit()
+}
+
+func (r *UserRepo) AddRole(c context.Context, id string, role string) (*models.User, error) {
+	return r.changeGrant(c, "user_roles", "role", id, role, true)
+}
+
+func (r *UserRepo) RemoveRole(c context.Context, id string, role string) (*models.User, error) {
+	return r.changeGrant(c, "user_roles", "role", id, role, false)
+}
+
+func (r *UserRepo) AddPermission(c context.Context, id string, perm string) (*models.User, error) {
+	return r.changeGrant(c, "user_permissions", "permission", id, perm, true)
+}
+
+func (r *UserRepo) RemovePermission(c context.Context, id string, perm string) (*models.User, error) {
+	return r.changeGrant(c, "user_permissions", "permission", id, perm, false)
+}
+
+// Add or remove value in column of user's grants table, user_roles or user_permissions
+func (r *UserRepo) changeGrant(c context.Context, table string, column string, id string, value string, add bool) (*models.User, error) {
+	tx, err := r.db.BeginTx(c, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	uid, err := r.find(c, tx, &models.User{ID: id})
+	if err != nil {
+		return nil, err
+	}
+
+	// Failed statement aborts PostgreSQL transaction, so duplicate is checked first
+	var granted int
+	err = tx.QueryRowContext(c, r.db.rebind("SELECT COUNT(*) FROM "+table+" WHERE user_id = ? AND "+column+" = ?"), uid, value).Scan(&granted)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case add && granted == 0:
+		_, err = tx.ExecContext(c, r.db.rebind("INSERT INTO "+table+" (user_id, "+column+") VALUES (?, ?)"), uid, value)
+	case !add && granted > 0:
+		_, err = tx.ExecContext(c, r.db.rebind("DELETE FROM "+table+" WHERE user_id = ? AND "+column+" = ?"), uid, value)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := r.load(c, tx, "id = ?", uid)
+	if err != nil {
+		return nil, err
+	}
+	return user, tx.Commit()
+}
+
+// Id of the first user matching non-zero id, mysql_id and username of filter
+func (r *UserRepo) find(c context.Context, q querier, filt *models.User) (int64, error) {
+	var where []string
+	var args []interface{}
+	if filt.ID != "" {
+		id, err := strconv.ParseInt(filt.ID, 10, 64)
+		if err != nil {
+			return 0, e.ErrUserNotFound
+		}
+		where = append(where, "id = ?")
+		args = append(args, id)
+	}
+	if filt.MysqlID != 0 {
+		where = append(where, "mysql_id = ?")
+		args = append(args, filt.MysqlID)
+	}
+	if filt.Username != "" {
+		where = append(where, "username_key = ?")
+		args = append(args, models.UsernameKey(filt.Username))
+	}
+	// Empty filter would match anyone
+	if len(where) == 0 {
+		return 0, e.ErrUserNotFound
+	}
+
+	var id int64
+	query := "SELECT id FROM users WHERE " + strings.Join(where, " AND ") + " ORDER BY id LIMIT 1"
+	err := q.QueryRowContext(c, r.db.rebind(query), args...).Scan(&id)
+	if err == stdsql.ErrNoRows {
+		return 0, e.ErrUserNotFound
+	}
+	return id, err
+}
+
+// User with roles and permissions
+func (r *UserRepo) load(c context.Context, q querier, where string, arg interface{}) (*models.User, error) {
+	var (
+		id   int64
+		user models.User
+	)
+	query := "SELECT id, mysql_id, username, password FROM users WHERE " + where
+	err := q.QueryRowContext(c, r.db.rebind(query), arg).Scan(&id, &user.MysqlID, &user.Username, &user.Password)
+	if err == stdsql.ErrNoRows {
+		return nil, e.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.ID = strconv.FormatInt(id, 10)
+
+	if user.Roles, err = r.list(c, q, "SELECT role FROM user_roles WHERE user_id = ? ORDER BY role", id); err != nil {
+		return nil, err
+	}
+	if user.Permissions, err = r.list(c, q, "SELECT permission FROM user_permissions WHERE user_id = ? ORDER BY permission", id); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepo) list(c context.Context, q querier, query string, id int64) ([]string, error) {
+	rows, err := q.QueryContext(c, r.db.rebind(query), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// Replace all user's values in roles or permissions table
+func (r *UserRepo) replace(c context.Context, tx *stdsql.Tx, table string, column string, id int64, values []string) error {
+	if _, err := tx.ExecContext(c, r.db.rebind("DELETE FROM "+table+" WHERE user_id = ?"), id); err != nil {
+		return err
+	}
+	insert := r.db.rebind("INSERT INTO " + table + " (user_id, " + column + ") VALUES (?, ?)")
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		if _, err := tx.ExecContext(c, insert, id, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	context "context"
 	"example-grpc-auth/models"
+	"time"
 )
 
 // Users storage interface
@@ -10,11 +11,34 @@ type UserRepo interface {
 	CreateUser(c context.Context, u string, p string) error
 	GetUser(c context.Context, u string, p string) (*models.User, error)
 	UpdateUser(c context.Context, f *models.User, u *models.User) (*models.User, error)
-	DeleteUser(context.Context, *models.User) error
+	// Delete user matching filter and return it
+	DeleteUser(context.Context, *models.User) (*models.User, error)
+	GetUserByID(c context.Context, id string) (*models.User, error)
+	AddRole(c context.Context, id string, role string) (*models.User, error)
+	RemoveRole(c context.Context, id string, role string) (*models.User, error)
+	AddPermission(c context.Context, id string, perm string) (*models.User, error)
+	RemovePermission(c context.Context, id string, perm string) (*models.User, error)
 }
 
 // Tokens storage interface
 type TokenRepo interface {
-	RevokeToken(c context.Context, t string) error
-	IsRevoked(c context.Context, t string) (bool, error)
+	// Revoke token by jti until its expiration time. Already expired tokens are skipped.
+	RevokeToken(c context.Context, jti string, exp time.Time) error
+	IsRevoked(c context.Context, jti string) (bool, error)
+	// Revoke all tokens issued to user before given time
+	RevokeUserTokens(c context.Context, userID string, before time.Time) error
+	// Time before which user's tokens are revoked, zero if none
+	RevokedBefore(c context.Context, userID string) (time.Time, error)
+}
+
+// Refresh tokens storage interface
+type RefreshTokenRepo interface {
+	CreateRefreshToken(c context.Context, t *models.RefreshToken) error
+	// Token by id, without changing it
+	GetRefreshToken(c context.Context, id string) (*models.RefreshToken, error)
+	// Mark token as used and return its state before the update
+	UseRefreshToken(c context.Context, id string) (*models.RefreshToken, error)
+	RevokeFamily(c context.Context, familyID string) error
+	// Revoke all refresh token families of user
+	RevokeUserFamilies(c context.Context, userID string) error
 }
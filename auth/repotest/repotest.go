@@ -0,0 +1,597 @@
+// Package repotest is a conformance suite for auth repository implementations.
+// Every backend runs it from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		repotest.RunUserRepo(t, func(t *testing.T) auth.UserRepo { return NewUserRepo(...) })
+//	}
+//
+// Factory must return an empty repository on each call.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"example-grpc-auth/auth"
+	e "example-grpc-auth/err"
+	"example-grpc-auth/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Concurrent callers in race tests
+const workers = 16
+
+// Times are compared at millisecond precision, as stored by MongoDB
+const precision = time.Millisecond
+
+// User id embedded in refresh tokens, valid for every backend
+const (
+	userID      = "5f1d7f4c8a3b2e0012345678"
+	otherUserID = "5f1d7f4c8a3b2e0087654321"
+)
+
+// Password hash as stored by AuthServer
+func hash(t *testing.T, password string) string {
+	t.Helper()
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(h)
+}
+
+func createUser(t *testing.T, r auth.UserRepo, username string) *models.User {
+	t.Helper()
+	ctx := context.Background()
+	if err := r.CreateUser(ctx, username, hash(t, "pwd")); err != nil {
+		t.Fatalf("CreateUser(%s) error = %v", username, err)
+	}
+	u, err := r.GetUser(ctx, username, "pwd")
+	if err != nil {
+		t.Fatalf("GetUser(%s) error = %v", username, err)
+	}
+	return u
+}
+
+func wantErr(t *testing.T, op string, err error, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("%s error = %v, want %v", op, err, want)
+	}
+}
+
+func sorted(values []string) []string {
+	values = append([]string(nil), values...)
+	sort.Strings(values)
+	return values
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = sorted(a), sorted(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// UserRepo contract
+func RunUserRepo(t *testing.T, newRepo func(t *testing.T) auth.UserRepo) {
+	ctx := context.Background()
+
+	t.Run("create and get", func(t *testing.T) {
+		r := newRepo(t)
+		u := createUser(t, r, "alice")
+		if u.ID == "" || u.Username != "alice" {
+			t.Errorf("GetUser() = %+v, want alice with id", u)
+		}
+		if len(u.Roles) != 0 || len(u.Permissions) != 0 {
+			t.Errorf("GetUser() = %+v, want no roles and permissions", u)
+		}
+
+		byID, err := r.GetUserByID(ctx, u.ID)
+		if err != nil || byID.ID != u.ID || byID.Username != "alice" {
+			t.Errorf("GetUserByID() = %+v, %v", byID, err)
+		}
+	})
+
+	t.Run("not found and invalid credentials", func(t *testing.T) {
+		r := newRepo(t)
+		u := createUser(t, r, "alice")
+
+		_, err := r.GetUser(ctx, "alice", "wrong")
+		wantErr(t, "GetUser() wrong password", err, e.ErrInvalidCred)
+		_, err = r.GetUser(ctx, "bob", "pwd")
+		wantErr(t, "GetUser() unknown user", err, e.ErrUserNotFound)
+		_, err = r.GetUserByID(ctx, u.ID+"0")
+		wantErr(t, "GetUserByID() unknown id", err, e.ErrUserNotFound)
+		_, err = r.GetUserByID(ctx, "not an id")
+		wantErr(t, "GetUserByID() invalid id", err, e.ErrUserNotFound)
+	})
+
+	t.Run("duplicate username", func(t *testing.T) {
+		r := newRepo(t)
+		createUser(t, r, "alice")
+
+		wantErr(t, "CreateUser() duplicate", r.CreateUser(ctx, "alice", hash(t, "other")), e.ErrDupKey)
+		// Original password is kept
+		if _, err := r.GetUser(ctx, "alice", "pwd"); err != nil {
+			t.Errorf("GetUser() after duplicate = %v", err)
+		}
+	})
+
+	t.Run("update by id and by username", func(t *testing.T) {
+		r := newRepo(t)
+		u := createUser(t, r, "alice")
+
+		got, err := r.UpdateUser(ctx, &models.User{ID: u.ID}, &models.User{MysqlID: 7})
+		if err != nil {
+			t.Fatalf("UpdateUser() by id error = %v", err)
+		}
+		// Zero fields are left as is
+		if got.ID != u.ID || got.Username != "alice" || got.MysqlID != 7 {
+			t.Errorf("UpdateUser() by id = %+v", got)
+		}
+
+		got, err = r.UpdateUser(ctx, &models.User{Username: "alice"}, &models.User{Username: "alice2", Password: hash(t, "new")})
+		if err != nil {
+			t.Fatalf("UpdateUser() by username error = %v", err)
+		}
+		if got.ID != u.ID || got.Username != "alice2" || got.MysqlID != 7 {
+			t.Errorf("UpdateUser() by username = %+v", got)
+		}
+		if _, err := r.GetUser(ctx, "alice2", "new"); err != nil {
+			t.Errorf("GetUser() with new password error = %v", err)
+		}
+
+		got, err = r.UpdateUser(ctx, &models.User{MysqlID: 7}, &models.User{Roles: []string{"editor"}, Permissions: []string{"orders:read"}})
+		if err != nil {
+			t.Fatalf("UpdateUser() by mysql id error = %v", err)
+		}
+		if !equal(got.Roles, []string{"editor"}) || !equal(got.Permissions, []string{"orders:read"}) {
+			t.Errorf("UpdateUser() roles and permissions = %+v", got)
+		}
+	})
+
+	t.Run("update errors", func(t *testing.T) {
+		r := newRepo(t)
+		u := createUser(t, r, "alice")
+		createUser(t, r, "bob")
+
+		_, err := r.UpdateUser(ctx, &models.User{ID: u.ID}, &models.User{Username: "bob"})
+		wantErr(t, "UpdateUser() to taken username", err, e.ErrDupKey)
+		_, err = r.UpdateUser(ctx, &models.User{Username: "carol"}, &models.User{MysqlID: 1})
+		wantErr(t, "UpdateUser() unknown user", err, e.ErrUserNotFound)
+		_, err = r.UpdateUser(ctx, &models.User{ID: "not an id"}, &models.User{MysqlID: 1})
+		wantErr(t, "UpdateUser() invalid id", err, e.ErrUserNotFound)
+		// Filter fields are combined
+		_, err = r.UpdateUser(ctx, &models.User{ID: u.ID, Username: "bob"}, &models.User{MysqlID: 1})
+		wantErr(t, "UpdateUser() id and username of different users", err, e.ErrUserNotFound)
+		// Empty filter matches nobody
+		_, err = r.UpdateUser(ctx, &models.User{}, &models.User{MysqlID: 1})
+		wantErr(t, "UpdateUser() empty filter", err, e.ErrUserNotFound)
+
+		for _, name := range []string{"alice", "bob"} {
+			if got, _ := r.GetUser(ctx, name, "pwd"); got == nil || got.MysqlID != 0 {
+				t.Errorf("user %s = %+v, want unchanged", name, got)
+			}
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		r := newRepo(t)
+		u := createUser(t, r, "alice")
+		createUser(t, r, "bob")
+
+		for _, filt := range []*models.User{{}, {ID: "not an id"}, {Username: "carol"}} {
+			_, err := r.DeleteUser(ctx, filt)
+			wantErr(t, fmt.Sprintf("DeleteUser(%+v)", filt), err, e.ErrUserNotFound)
+		}
+
+		deleted, err := r.DeleteUser(ctx, &models.User{ID: u.ID})
+		if err != nil {
+			t.Fatalf("DeleteUser() error = %v", err)
+		}
+		if deleted.ID != u.ID || deleted.Username != "alice" {
+			t.Errorf("DeleteUser() = %+v, want alice", deleted)
+		}
+		_, err = r.DeleteUser(ctx, &models.User{ID: u.ID})
+		wantErr(t, "DeleteUser() twice", err, e.ErrUserNotFound)
+		_, err = r.GetUserByID(ctx, u.ID)
+		wantErr(t, "GetUserByID() deleted", err, e.ErrUserNotFound)
+
+		if deleted, err := r.DeleteUser(ctx, &models.User{Username: "bob"}); err != nil || deleted.Username != "bob" {
+			t.Errorf("DeleteUser() by username = %+v, %v, want bob", deleted, err)
+		}
+		// Username is free again
+		createUser(t, r, "alice")
+	})
+
+	t.Run("usernames ignore case and normalization", func(t *testing.T) {
+		r := newRepo(t)
+		h := hash(t, "pwd")
+		// Decomposed é
+		if err := r.CreateUser(ctx, "Jos\u0065\u0301", h); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"jos\u00e9", "JOS\u00c9", "Jos\u0065\u0301"} {
+			err := r.CreateUser(ctx, name, h)
+			wantErr(t, fmt.Sprintf("CreateUser(%q)", name), err, e.ErrDupKey)
+		}
+		// Diacritics are significant
+		if err := r.CreateUser(ctx, "jose", h); err != nil {
+			t.Errorf("CreateUser(jose) error = %v", err)
+		}
+
+		// Sign in with any case, stored in composed form
+		if u, err := r.GetUser(ctx, "JOS\u00c9", "pwd"); err != nil || u.Username != "Jos\u00e9" {
+			t.Errorf("GetUser() other case = %+v, %v", u, err)
+		}
+		u, err := r.UpdateUser(ctx, &models.User{Username: "jos\u00e9"}, &models.User{MysqlID: 1})
+		if err != nil || u.Username != "Jos\u00e9" || u.MysqlID != 1 {
+			t.Errorf("UpdateUser() by other case = %+v, %v", u, err)
+		}
+		_, err = r.UpdateUser(ctx, &models.User{Username: "jose"}, &models.User{Username: "JOS\u00c9"})
+		wantErr(t, "UpdateUser() to other case of taken username", err, e.ErrDupKey)
+		// Own username in other case
+		u, err = r.UpdateUser(ctx, &models.User{Username: "jose"}, &models.User{Username: "Jose"})
+		if err != nil || u.Username != "Jose" {
+			t.Errorf("UpdateUser() to own username in other case = %+v, %v", u, err)
+		}
+	})
+
+	t.Run("roles", func(t *testing.T) {
+		r := newRepo(t)
+		u := createUser(t, r, "alice")
+
+		got, err := r.AddRole(ctx, u.ID, "admin")
+		if err != nil || !equal(got.Roles, []string{"admin"}) {
+			t.Errorf("AddRole() = %+v, %v", got, err)
+		}
+		got, err = r.AddRole(ctx, u.ID, "admin")
+		if err != nil || !equal(got.Roles, []string{"admin"}) {
+			t.Errorf("AddRole() twice = %+v, %v, want single role", got, err)
+		}
+		got, err = r.AddRole(ctx, u.ID, "editor")
+		if err != nil || !equal(got.Roles, []string{"admin", "editor"}) {
+			t.Errorf("AddRole() second role = %+v, %v", got, err)
+		}
+		got, err = r.RemoveRole(ctx, u.ID, "admin")
+		if err != nil || !equal(got.Roles, []string{"editor"}) {
+			t.Errorf("RemoveRole() = %+v, %v", got, err)
+		}
+		got, err = r.RemoveRole(ctx, u.ID, "admin")
+		if err != nil || !equal(got.Roles, []string{"editor"}) {
+			t.Errorf("RemoveRole() missing role = %+v, %v", got, err)
+		}
+		if got, _ := r.GetUserByID(ctx, u.ID); got == nil || !equal(got.Roles, []string{"editor"}) {
+			t.Errorf("GetUserByID() after role changes = %+v", got)
+		}
+
+		_, err = r.AddRole(ctx, "not an id", "admin")
+		wantErr(t, "AddRole() invalid id", err, e.ErrUserNotFound)
+		_, err = r.RemoveRole(ctx, u.ID+"0", "admin")
+		wantErr(t, "RemoveRole() unknown id", err, e.ErrUserNotFound)
+	})
+
+	t.Run("permissions", func(t *testing.T) {
+		r := newRepo(t)
+		u := createUser(t, r, "alice")
+
+		got, err := r.AddPermission(ctx, u.ID, "orders:read")
+		if err != nil || !equal(got.Permissions, []string{"orders:read"}) {
+			t.Errorf("AddPermission() = %+v, %v", got, err)
+		}
+		got, err = r.AddPermission(ctx, u.ID, "orders:read")
+		if err != nil || !equal(got.Permissions, []string{"orders:read"}) {
+			t.Errorf("AddPermission() twice = %+v, %v, want single permission", got, err)
+		}
+		got, err = r.AddPermission(ctx, u.ID, "reports:*")
+		if err != nil || !equal(got.Permissions, []string{"orders:read", "reports:*"}) {
+			t.Errorf("AddPermission() second permission = %+v, %v", got, err)
+		}
+		got, err = r.RemovePermission(ctx, u.ID, "orders:read")
+		if err != nil || !equal(got.Permissions, []string{"reports:*"}) {
+			t.Errorf("RemovePermission() = %+v, %v", got, err)
+		}
+		if got, _ := r.GetUserByID(ctx, u.ID); got == nil || !equal(got.Permissions, []string{"reports:*"}) || len(got.Roles) != 0 {
+			t.Errorf("GetUserByID() after permission changes = %+v", got)
+		}
+
+		_, err = r.AddPermission(ctx, "not an id", "orders:read")
+		wantErr(t, "AddPermission() invalid id", err, e.ErrUserNotFound)
+		_, err = r.RemovePermission(ctx, u.ID+"0", "orders:read")
+		wantErr(t, "RemovePermission() unknown id", err, e.ErrUserNotFound)
+	})
+
+	t.Run("concurrent sign up with same username", func(t *testing.T) {
+		r := newRepo(t)
+		h := hash(t, "pwd")
+
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for n := 0; n < workers; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- r.CreateUser(ctx, "alice", h)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		created := 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				created++
+			case !errors.Is(err, e.ErrDupKey):
+				t.Errorf("CreateUser() error = %v, want nil or ErrDupKey", err)
+			}
+		}
+		if created != 1 {
+			t.Errorf("%d users created, want 1", created)
+		}
+	})
+
+	t.Run("concurrent role changes", func(t *testing.T) {
+		r := newRepo(t)
+		u := createUser(t, r, "alice")
+
+		var wg sync.WaitGroup
+		var want []string
+		for n := 0; n < workers; n++ {
+			role := fmt.Sprintf("role-%d", n)
+			want = append(want, role)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := r.AddRole(ctx, u.ID, role); err != nil {
+					t.Errorf("AddRole() error = %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		got, err := r.GetUserByID(ctx, u.ID)
+		if err != nil || !equal(got.Roles, want) {
+			t.Errorf("GetUserByID() roles = %v, %v, want %v", got.Roles, err, want)
+		}
+	})
+}
+
+// TokenRepo contract
+func RunTokenRepo(t *testing.T, newRepo func(t *testing.T) auth.TokenRepo) {
+	ctx := context.Background()
+
+	t.Run("revoke token", func(t *testing.T) {
+		r := newRepo(t)
+		if err := r.RevokeToken(ctx, "jti-1", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("RevokeToken() error = %v", err)
+		}
+		// Revoking twice is fine
+		if err := r.RevokeToken(ctx, "jti-1", time.Now().Add(time.Hour)); err != nil {
+			t.Errorf("RevokeToken() twice error = %v", err)
+		}
+		// Expired token is skipped, not an error
+		if err := r.RevokeToken(ctx, "jti-expired", time.Now().Add(-time.Hour)); err != nil {
+			t.Errorf("RevokeToken() expired error = %v", err)
+		}
+
+		for jti, want := range map[string]bool{"jti-1": true, "jti-2": false, "jti-expired": false} {
+			if ok, err := r.IsRevoked(ctx, jti); err != nil || ok != want {
+				t.Errorf("IsRevoked(%s) = %v, %v, want %v", jti, ok, err, want)
+			}
+		}
+	})
+
+	t.Run("revoke user tokens", func(t *testing.T) {
+		r := newRepo(t)
+		if before, err := r.RevokedBefore(ctx, "user-1"); err != nil || !before.IsZero() {
+			t.Errorf("RevokedBefore() = %v, %v, want zero time", before, err)
+		}
+
+		now := time.Now().Truncate(precision)
+		if err := r.RevokeUserTokens(ctx, "user-1", now); err != nil {
+			t.Fatalf("RevokeUserTokens() error = %v", err)
+		}
+		// Marker never moves back
+		if err := r.RevokeUserTokens(ctx, "user-1", now.Add(-time.Hour)); err != nil {
+			t.Fatalf("RevokeUserTokens() error = %v", err)
+		}
+		if before, err := r.RevokedBefore(ctx, "user-1"); err != nil || !before.Equal(now) {
+			t.Errorf("RevokedBefore() = %v, %v, want %v", before, err, now)
+		}
+		if before, err := r.RevokedBefore(ctx, "user-2"); err != nil || !before.IsZero() {
+			t.Errorf("RevokedBefore() other user = %v, %v, want zero time", before, err)
+		}
+
+		later := now.Add(time.Minute)
+		if err := r.RevokeUserTokens(ctx, "user-1", later); err != nil {
+			t.Fatalf("RevokeUserTokens() error = %v", err)
+		}
+		if before, err := r.RevokedBefore(ctx, "user-1"); err != nil || !before.Equal(later) {
+			t.Errorf("RevokedBefore() = %v, %v, want %v", before, err, later)
+		}
+	})
+
+	t.Run("concurrent revocations", func(t *testing.T) {
+		r := newRepo(t)
+		base := time.Now().Truncate(precision)
+
+		var wg sync.WaitGroup
+		for n := 0; n < workers; n++ {
+			n := n
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := r.RevokeToken(ctx, fmt.Sprintf("jti-%d", n), time.Now().Add(time.Hour)); err != nil {
+					t.Errorf("RevokeToken() error = %v", err)
+				}
+				if err := r.RevokeUserTokens(ctx, "user-1", base.Add(time.Duration(n)*time.Second)); err != nil {
+					t.Errorf("RevokeUserTokens() error = %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		for n := 0; n < workers; n++ {
+			if ok, err := r.IsRevoked(ctx, fmt.Sprintf("jti-%d", n)); err != nil || !ok {
+				t.Errorf("IsRevoked(jti-%d) = %v, %v, want true", n, ok, err)
+			}
+		}
+		// Latest marker wins regardless of write order
+		want := base.Add(time.Duration(workers-1) * time.Second)
+		if before, err := r.RevokedBefore(ctx, "user-1"); err != nil || !before.Equal(want) {
+			t.Errorf("RevokedBefore() = %v, %v, want %v", before, err, want)
+		}
+	})
+}
+
+// RefreshTokenRepo contract
+func RunRefreshTokenRepo(t *testing.T, newRepo func(t *testing.T) auth.RefreshTokenRepo) {
+	ctx := context.Background()
+	newToken := func(id string, family string) *models.RefreshToken {
+		return &models.RefreshToken{
+			ID:        id,
+			FamilyID:  family,
+			User:      models.User{ID: userID, Username: "alice", MysqlID: 7},
+			IssuedAt:  time.Now().Truncate(precision),
+			ExpiresAt: time.Now().Add(time.Hour).Truncate(precision),
+		}
+	}
+
+	t.Run("use", func(t *testing.T) {
+		r := newRepo(t)
+		token := newToken("hash-1", "family-1")
+		if err := r.CreateRefreshToken(ctx, token); err != nil {
+			t.Fatalf("CreateRefreshToken() error = %v", err)
+		}
+
+		// Lookup leaves token unused
+		got, err := r.GetRefreshToken(ctx, "hash-1")
+		if err != nil || got.ID != token.ID || got.FamilyID != token.FamilyID || got.User.ID != userID ||
+			!got.ExpiresAt.Equal(token.ExpiresAt) || got.Used || got.Revoked {
+			t.Errorf("GetRefreshToken() = %+v, %v, want %+v", got, err, token)
+		}
+		_, err = r.GetRefreshToken(ctx, "unknown")
+		wantErr(t, "GetRefreshToken() unknown", err, e.ErrInvalidRefresh)
+
+		got, err = r.UseRefreshToken(ctx, "hash-1")
+		if err != nil {
+			t.Fatalf("UseRefreshToken() error = %v", err)
+		}
+		if got.ID != token.ID || got.FamilyID != token.FamilyID || got.User.ID != userID ||
+			got.User.Username != "alice" || got.User.MysqlID != 7 ||
+			!got.IssuedAt.Equal(token.IssuedAt) || !got.ExpiresAt.Equal(token.ExpiresAt) ||
+			got.Used || got.Revoked {
+			t.Errorf("UseRefreshToken() = %+v, want %+v", got, token)
+		}
+
+		// State before the call is returned
+		got, err = r.UseRefreshToken(ctx, "hash-1")
+		if err != nil || !got.Used {
+			t.Errorf("UseRefreshToken() twice = %+v, %v, want used", got, err)
+		}
+
+		_, err = r.UseRefreshToken(ctx, "unknown")
+		wantErr(t, "UseRefreshToken() unknown", err, e.ErrInvalidRefresh)
+		if got, err := r.GetRefreshToken(ctx, "hash-1"); err != nil || !got.Used {
+			t.Errorf("GetRefreshToken() after use = %+v, %v, want used", got, err)
+		}
+	})
+
+	t.Run("revoke family", func(t *testing.T) {
+		r := newRepo(t)
+		for _, token := range []*models.RefreshToken{
+			newToken("hash-1", "family-1"),
+			newToken("hash-2", "family-1"),
+			newToken("hash-3", "family-2"),
+		} {
+			if err := r.CreateRefreshToken(ctx, token); err != nil {
+				t.Fatalf("CreateRefreshToken() error = %v", err)
+			}
+		}
+
+		if err := r.RevokeFamily(ctx, "family-1"); err != nil {
+			t.Fatalf("RevokeFamily() error = %v", err)
+		}
+		for id, want := range map[string]bool{"hash-1": true, "hash-2": true, "hash-3": false} {
+			if got, err := r.UseRefreshToken(ctx, id); err != nil || got.Revoked != want {
+				t.Errorf("UseRefreshToken(%s) = %+v, %v, want revoked %v", id, got, err, want)
+			}
+		}
+	})
+
+	t.Run("revoke user families", func(t *testing.T) {
+		r := newRepo(t)
+		other := newToken("hash-3", "family-3")
+		other.User.ID = otherUserID
+		for _, token := range []*models.RefreshToken{
+			newToken("hash-1", "family-1"),
+			newToken("hash-2", "family-2"),
+			other,
+		} {
+			if err := r.CreateRefreshToken(ctx, token); err != nil {
+				t.Fatalf("CreateRefreshToken() error = %v", err)
+			}
+		}
+
+		if err := r.RevokeUserFamilies(ctx, userID); err != nil {
+			t.Fatalf("RevokeUserFamilies() error = %v", err)
+		}
+		if err := r.RevokeUserFamilies(ctx, "not an id"); err != nil {
+			t.Errorf("RevokeUserFamilies() invalid id error = %v", err)
+		}
+		for id, want := range map[string]bool{"hash-1": true, "hash-2": true, "hash-3": false} {
+			if got, err := r.UseRefreshToken(ctx, id); err != nil || got.Revoked != want {
+				t.Errorf("UseRefreshToken(%s) = %+v, %v, want revoked %v", id, got, err, want)
+			}
+		}
+	})
+
+	t.Run("concurrent use", func(t *testing.T) {
+		r := newRepo(t)
+		if err := r.CreateRefreshToken(ctx, newToken("hash-1", "family-1")); err != nil {
+			t.Fatalf("CreateRefreshToken() error = %v", err)
+		}
+
+		var wg sync.WaitGroup
+		unused := make(chan bool, workers)
+		for n := 0; n < workers; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got, err := r.UseRefreshToken(ctx, "hash-1")
+				if err != nil {
+					t.Errorf("UseRefreshToken() error = %v", err)
+					return
+				}
+				unused <- !got.Used
+			}()
+		}
+		wg.Wait()
+		close(unused)
+
+		winners := 0
+		for ok := range unused {
+			if ok {
+				winners++
+			}
+		}
+		// Exactly one exchange may succeed
+		if winners != 1 {
+			t.Errorf("%d callers saw unused token, want 1", winners)
+		}
+	})
+}
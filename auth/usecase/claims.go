@@ -0,0 +1,145 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	e "example-grpc-auth/err"
+	"example-grpc-auth/models"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Access token claims. User id goes to standard "sub" claim,
+// Username, MysqlID, Roles and Permissions are the only custom claims allowed in a token.
+type AuthClaims struct {
+	Username    string   `json:"username,omitempty"`
+	MysqlID     int      `json:"mysql_id,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Token issuing and validation options
+type Options struct {
+	// Access token lifetime, 15 minutes by default
+	AccessTTL time.Duration
+	// Refresh token lifetime, 30 days by default
+	RefreshTTL time.Duration
+	// Value of "iss" claim, checked on parsing when set
+	Issuer string
+	// Value of "aud" claim, checked on parsing when set
+	Audience string
+	// Tokens in the old format, embedding models.User, are accepted until this time
+	LegacyUntil time.Time
+	// Accept tokens when revocation lookup fails. By default such tokens
+	// are rejected with codes.Unavailable.
+	RevocationFailOpen bool
+}
+
+// Validated access token
+type accessToken struct {
+	raw       string
+	jti       string
+	user      *models.User
+	issuedAt  time.Time
+	expiresAt time.Time
+}
+
+// Token id for revocation. Legacy tokens carry no jti, SHA-1 hash of
+// token string was used as revocation key for them.
+func tokenID(c *parsedClaims, raw string) string {
+	if c.ID != "" {
+		return c.ID
+	}
+	sum := sha1.Sum([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// Claims of tokens issued before AuthClaims redesign.
+// Full models.User including password hash was embedded.
+type legacyClaims struct {
+	User *models.User `json:"User,omitempty"`
+}
+
+// Both current and legacy claims, to tell formats apart on parsing
+type parsedClaims struct {
+	AuthClaims
+	legacyClaims
+}
+
+func (s *AuthServer) newClaims(user *models.User, o *Options) (*AuthClaims, error) {
+	jti, err := newJTI()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	claims := &AuthClaims{
+		Username:    user.Username,
+		MysqlID:     user.MysqlID,
+		Roles:       user.Roles,
+		Permissions: user.Permissions,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID,
+			Issuer:    o.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(o.AccessTTL)),
+			ID:        jti,
+		},
+	}
+	if o.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{o.Audience}
+	}
+	return claims, nil
+}
+
+// Check claims beyond expiration and signature, and get token owner
+func verifyClaims(c *parsedClaims, o *Options) (*models.User, error) {
+	// Token without expiration could never be dropped from revocation list
+	if c.ExpiresAt == nil {
+		return nil, e.ErrInvalidAccessToken
+	}
+
+	// Old format token, no standard claims except "exp"
+	if c.User != nil && c.Subject == "" {
+		if !time.Now().Before(o.LegacyUntil) {
+			return nil, e.ErrInvalidAccessToken
+		}
+		return &models.User{
+			ID:       c.User.ID,
+			MysqlID:  c.User.MysqlID,
+			Username: c.User.Username,
+		}, nil
+	}
+
+	if c.Subject == "" {
+		return nil, e.ErrInvalidAccessToken
+	}
+	if o.Issuer != "" && !c.VerifyIssuer(o.Issuer, true) {
+		return nil, e.ErrInvalidAccessToken
+	}
+	if o.Audience != "" && !c.VerifyAudience(o.Audience, true) {
+		return nil, e.ErrInvalidAccessToken
+	}
+
+	return &models.User{
+		ID:          c.Subject,
+		MysqlID:     c.MysqlID,
+		Username:    c.Username,
+		Roles:       c.Roles,
+		Permissions: c.Permissions,
+	}, nil
+}
+
+// Random 128-bit token id
+func newJTI() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
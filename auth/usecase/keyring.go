@@ -0,0 +1,164 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownKey = errors.New("unknown signing key")
+	ErrRetiredKey = errors.New("signing key retired")
+)
+
+// Set of signing keys. New tokens are signed with the active key,
+// tokens signed with any other non-retired key still verify.
+// Safe for concurrent use, keys can be rotated at runtime.
+type KeyRing struct {
+	mu      sync.RWMutex
+	active  *SigningKey
+	keys    map[string]*SigningKey
+	retired map[string]bool
+	// Keys dropped by Sync, verify-only until the deadline
+	retiring map[string]time.Time
+}
+
+// Key ring with active key and additional verification-only keys
+func NewKeyRing(active *SigningKey, keys ...*SigningKey) *KeyRing {
+	r := &KeyRing{
+		active:   active,
+		keys:     map[string]*SigningKey{active.ID: active},
+		retired:  make(map[string]bool),
+		retiring: make(map[string]time.Time),
+	}
+	for _, k := range keys {
+		r.keys[k.ID] = k
+	}
+	return r
+}
+
+// Key for signing new tokens
+func (r *KeyRing) Active() *SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.active
+}
+
+// Key by id, for verification
+func (r *KeyRing) Lookup(kid string) (*SigningKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.retired[kid] || r.expired(kid, time.Now()) {
+		return nil, ErrRetiredKey
+	}
+	k, ok := r.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return k, nil
+}
+
+// All non-retired keys
+func (r *KeyRing) Keys() []*SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	keys := make([]*SigningKey, 0, len(r.keys))
+	for _, k := range r.keys {
+		if !r.expired(k.ID, now) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Key dropped by Sync and past its deadline
+func (r *KeyRing) expired(kid string, now time.Time) bool {
+	deadline, ok := r.retiring[kid]
+	return ok && !now.Before(deadline)
+}
+
+// Add key and start signing with it. Previous active key stays valid for verification.
+func (r *KeyRing) Rotate(k *SigningKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.retired, k.ID)
+	delete(r.retiring, k.ID)
+	r.keys[k.ID] = k
+	r.active = k
+}
+
+// Stop accepting tokens signed with key. Active key can't be retired.
+func (r *KeyRing) Retire(kid string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.retire(kid)
+}
+
+func (r *KeyRing) retire(kid string) error {
+	if _, ok := r.keys[kid]; !ok {
+		return ErrUnknownKey
+	}
+	if r.active.ID == kid {
+		return fmt.Errorf("can't retire active key %s", kid)
+	}
+	delete(r.keys, kid)
+	delete(r.retiring, kid)
+	r.retired[kid] = true
+	return nil
+}
+
+// Replace ring content, e.g. on config reload. Keys missing from the new
+// set still verify for grace, so tokens signed with them live out their
+// lifetime, and are retired after that. Zero grace retires them at once.
+func (r *KeyRing) Sync(active string, keys []*SigningKey, grace time.Duration) error {
+	var next *SigningKey
+	for _, k := range keys {
+		if k.ID == active {
+			next = k
+		}
+	}
+	if next == nil {
+		return fmt.Errorf("active key %s: %w", active, ErrUnknownKey)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.active = next
+	for _, k := range keys {
+		delete(r.retired, k.ID)
+		delete(r.retiring, k.ID)
+		r.keys[k.ID] = k
+	}
+	for kid := range r.keys {
+		if containsKey(keys, kid) {
+			continue
+		}
+		if _, ok := r.retiring[kid]; !ok && grace > 0 {
+			r.retiring[kid] = now.Add(grace)
+		}
+		if grace <= 0 || r.expired(kid, now) {
+			if err := r.retire(kid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func containsKey(keys []*SigningKey, kid string) bool {
+	for _, k := range keys {
+		if k.ID == kid {
+			return true
+		}
+	}
+	return false
+}
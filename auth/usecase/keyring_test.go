@@ -0,0 +1,212 @@
+// nolint
+package usecase
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "example-grpc-auth/api"
+	"example-grpc-auth/auth/repo/mock"
+	"example-grpc-auth/models"
+
+	"github.com/golang-jwt/jwt/v4"
+	mc "github.com/stretchr/testify/mock"
+)
+
+func TestKeyRing_Rotation(t *testing.T) {
+	k1 := NewHMACKey([]byte("secret-1"))
+	k2 := NewHMACKey([]byte("secret-2"))
+	ring := NewKeyRing(k1)
+
+	refreshRepo := new(mock.RefreshTokenRepoMock)
+	refreshRepo.On("CreateRefreshToken", mc.Anything).Return(nil)
+	tokenRepo := new(mock.TokenRepoMock)
+	tokenRepo.On("IsRevoked", mc.Anything).Return(false, nil)
+	tokenRepo.On("RevokedBefore", mc.Anything).Return(time.Time{}, nil)
+	s := NewAuthServer(new(mock.UserRepoMock), tokenRepo, refreshRepo, ring, Options{})
+
+	issue := func() string {
+		resp, err := s.issueTokens(context.Background(), &models.User{ID: "1", Username: "test"}, "family")
+		if err != nil {
+			t.Fatalf("issueTokens() error = %v", err)
+		}
+		return resp.Token
+	}
+	parse := func(token string) error {
+		_, err := s.ParseToken(context.Background(), &pb.ParseRequest{Token: token})
+		return err
+	}
+	kid := func(token string) string {
+		parsed, _, err := new(jwt.Parser).ParseUnverified(token, &AuthClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Header["kid"].(string)
+	}
+
+	old := issue()
+	if kid(old) != k1.ID {
+		t.Errorf("kid = %s, want %s", kid(old), k1.ID)
+	}
+
+	// Rotated key signs new tokens, old tokens still verify
+	ring.Rotate(k2)
+	fresh := issue()
+	if kid(fresh) != k2.ID {
+		t.Errorf("kid = %s, want %s", kid(fresh), k2.ID)
+	}
+	if err := parse(old); err != nil {
+		t.Errorf("ParseToken(old) error = %v", err)
+	}
+	if err := parse(fresh); err != nil {
+		t.Errorf("ParseToken(fresh) error = %v", err)
+	}
+
+	// Active key can't be retired
+	if err := ring.Retire(k2.ID); err == nil {
+		t.Errorf("Retire(active) error = nil")
+	}
+
+	// Without grace, keys missing from reloaded set are retired at once
+	if err := ring.Sync(k2.ID, []*SigningKey{k2}, 0); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if err := parse(old); err == nil {
+		t.Errorf("ParseToken(old) accepted token signed with retired key")
+	}
+	if _, err := ring.Lookup(k1.ID); !errors.Is(err, ErrRetiredKey) {
+		t.Errorf("Lookup(retired) error = %v, want %v", err, ErrRetiredKey)
+	}
+	if err := parse(fresh); err != nil {
+		t.Errorf("ParseToken(fresh) error = %v", err)
+	}
+
+	// Active key must be part of the set
+	if err := ring.Sync("missing", []*SigningKey{k2}, 0); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Sync() error = %v, want %v", err, ErrUnknownKey)
+	}
+
+	// Unknown kid is rejected
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, AuthClaims{Username: "test", RegisteredClaims: jwt.RegisteredClaims{Subject: "1"}})
+	forged.Header["kid"] = "unknown"
+	fs, _ := forged.SignedString([]byte("secret-2"))
+	if err := parse(fs); err == nil {
+		t.Errorf("ParseToken() accepted token with unknown kid")
+	}
+}
+
+func TestKeyRing_SyncGrace(t *testing.T) {
+	oldKey := NewHMACKey([]byte("old-secret"))
+	newKey := NewHMACKey([]byte("new-secret"))
+	ring := NewKeyRing(oldKey)
+
+	refreshRepo := new(mock.RefreshTokenRepoMock)
+	refreshRepo.On("CreateRefreshToken", mc.Anything).Return(nil)
+	tokenRepo := new(mock.TokenRepoMock)
+	tokenRepo.On("IsRevoked", mc.Anything).Return(false, nil)
+	tokenRepo.On("RevokedBefore", mc.Anything).Return(time.Time{}, nil)
+	s := NewAuthServer(new(mock.UserRepoMock), tokenRepo, refreshRepo, ring, Options{})
+	parse := func(token string) error {
+		_, err := s.ParseToken(context.Background(), &pb.ParseRequest{Token: token})
+		return err
+	}
+
+	// Issued before jwtsecret is replaced by config reload
+	resp, err := s.issueTokens(context.Background(), &models.User{ID: "1", Username: "test"}, "family")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := resp.Token
+	if err := ring.Sync(newKey.ID, []*SigningKey{newKey}, s.AccessTTL()); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if ring.Active().ID != newKey.ID {
+		t.Errorf("Active() = %s, want %s", ring.Active().ID, newKey.ID)
+	}
+	if err := parse(before); err != nil {
+		t.Errorf("ParseToken() of token issued before rotation error = %v", err)
+	}
+	if len(ring.Keys()) != 2 {
+		t.Errorf("Keys() = %d keys, want old key published until retired", len(ring.Keys()))
+	}
+
+	// Another reload doesn't extend the grace period
+	deadline := ring.retiring[oldKey.ID]
+	if err := ring.Sync(newKey.ID, []*SigningKey{newKey}, 2*s.AccessTTL()); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if ring.retiring[oldKey.ID] != deadline {
+		t.Errorf("Sync() moved deadline from %v to %v", deadline, ring.retiring[oldKey.ID])
+	}
+
+	// Past access token lifetime the old key is retired
+	ring.retiring[oldKey.ID] = time.Now().Add(-time.Second)
+	if err := parse(before); err == nil {
+		t.Errorf("ParseToken() accepted token signed with expired key")
+	}
+	if len(ring.Keys()) != 1 {
+		t.Errorf("Keys() = %d keys, want 1", len(ring.Keys()))
+	}
+	if err := ring.Sync(newKey.ID, []*SigningKey{newKey}, s.AccessTTL()); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if _, err := ring.Lookup(oldKey.ID); !errors.Is(err, ErrRetiredKey) {
+		t.Errorf("Lookup() after grace error = %v, want %v", err, ErrRetiredKey)
+	}
+}
+
+func TestLoadKeyDir(t *testing.T) {
+	dir := t.TempDir()
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	files := map[string][]byte{
+		"2024-01.secret": []byte(strings.Repeat("s", 32) + "\n"),
+		"2024-02.pem":    pemKey(t, ecKey),
+		"README":         []byte("ignored"),
+	}
+	for name, b := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := LoadKeyDir(dir)
+	if err != nil {
+		t.Fatalf("LoadKeyDir() error = %v", err)
+	}
+	got := make(map[string]string)
+	for _, k := range keys {
+		got[k.ID] = k.Method.Alg()
+	}
+	want := map[string]string{"2024-01": "HS256", "2024-02": "ES384"}
+	if len(got) != len(want) || got["2024-01"] != want["2024-01"] || got["2024-02"] != want["2024-02"] {
+		t.Errorf("LoadKeyDir() = %v, want %v", got, want)
+	}
+	for _, k := range keys {
+		if k.ID == "2024-01" && string(k.Private.([]byte)) != strings.Repeat("s", 32) {
+			t.Errorf("LoadKeyDir() secret = %q, want trimmed", k.Private)
+		}
+	}
+}
+
+func TestLoadKeyDir_WeakSecret(t *testing.T) {
+	for name, secret := range map[string]string{"empty": "", "short": "secret\n"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "k1.secret"), []byte(secret), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadKeyDir(dir); !errors.Is(err, ErrWeakSecret) {
+				t.Errorf("LoadKeyDir() error = %v, want %v", err, ErrWeakSecret)
+			}
+		})
+	}
+}
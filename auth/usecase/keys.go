@@ -0,0 +1,244 @@
+package usecase
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pb "example-grpc-auth/api"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// HS256 secrets shorter than the hash output are rejected, RFC 7518 3.2
+const minSecretLen = 32
+
+var ErrWeakSecret = fmt.Errorf("HS256 secret must be at least %d bytes", minSecretLen)
+
+// Token signing key
+type SigningKey struct {
+	// Key id. RFC 7638 thumbprint for asymmetric keys.
+	ID     string
+	Method jwt.SigningMethod
+	// Private key used for signing. []byte for HMAC.
+	Private interface{}
+	// Public key used for verification. Same []byte as Private for HMAC.
+	Public interface{}
+}
+
+// HS256 key with shared secret. Key id is derived from the secret.
+func NewHMACKey(secret []byte) *SigningKey {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("kid"))
+
+	return &SigningKey{
+		ID:      "hs-" + b64(mac.Sum(nil))[:16],
+		Method:  jwt.SigningMethodHS256,
+		Private: secret,
+		Public:  secret,
+	}
+}
+
+// Load PEM encoded private key from file
+func LoadPrivateKey(method string, file string) (*SigningKey, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(method, b)
+}
+
+// Load all keys from directory. Key id is the file name without extension:
+// <kid>.pem holds PEM encoded private key, signing method is derived from key type,
+// <kid>.secret holds HS256 shared secret. Other files are ignored.
+func LoadKeyDir(dir string) ([]*SigningKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*SigningKey
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		kid := strings.TrimSuffix(entry.Name(), ext)
+
+		var k *SigningKey
+		switch ext {
+		case ".pem":
+			if k, err = LoadPrivateKey("", filepath.Join(dir, entry.Name())); err != nil {
+				return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+			}
+		case ".secret":
+			b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			secret := bytes.TrimSpace(b)
+			if len(secret) < minSecretLen {
+				return nil, fmt.Errorf("%s: %w", entry.Name(), ErrWeakSecret)
+			}
+			k = NewHMACKey(secret)
+		default:
+			continue
+		}
+		k.ID = kid
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// Parse PEM encoded private key for asymmetric signing method.
+// Empty method is derived from key type: RS256, ES256/ES384/ES512 or EdDSA.
+func ParsePrivateKey(method string, b []byte) (*SigningKey, error) {
+	key, err := parsePEM(b)
+	if err != nil {
+		return nil, err
+	}
+	if method == "" {
+		method = defaultMethod(key)
+	}
+
+	m := jwt.GetSigningMethod(method)
+	if m == nil {
+		return nil, fmt.Errorf("unknown signing method %q", method)
+	}
+
+	k := &SigningKey{Method: m}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		switch m.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		default:
+			return nil, fmt.Errorf("RSA key can't be used with %s", method)
+		}
+		k.Private, k.Public = key, &key.PublicKey
+	case *ecdsa.PrivateKey:
+		if em, ok := m.(*jwt.SigningMethodECDSA); !ok || em.CurveBits != key.Curve.Params().BitSize {
+			return nil, fmt.Errorf("curve %s can't be used with %s", key.Curve.Params().Name, method)
+		}
+		k.Private, k.Public = key, &key.PublicKey
+	case ed25519.PrivateKey:
+		if _, ok := m.(*jwt.SigningMethodEd25519); !ok {
+			return nil, fmt.Errorf("Ed25519 key can't be used with %s", method)
+		}
+		k.Private, k.Public = key, key.Public()
+	}
+
+	if k.ID, err = thumbprint(k.jwk()); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func parsePEM(b []byte) (interface{}, error) {
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM(b); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPrivateKeyFromPEM(b); err == nil {
+		return key, nil
+	}
+	key, err := jwt.ParseEdPrivateKeyFromPEM(b)
+	if err != nil {
+		return nil, errors.New("unsupported private key, want PEM encoded RSA, EC or Ed25519 key")
+	}
+	return key, nil
+}
+
+func defaultMethod(key interface{}) string {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256.Alg()
+	case *ecdsa.PrivateKey:
+		switch key.Curve.Params().BitSize {
+		case 384:
+			return jwt.SigningMethodES384.Alg()
+		case 521:
+			return jwt.SigningMethodES512.Alg()
+		}
+		return jwt.SigningMethodES256.Alg()
+	}
+	return jwt.SigningMethodEdDSA.Alg()
+}
+
+// Public part of key in JWK format. Nil for HMAC keys, which must never be published.
+func (k *SigningKey) jwk() *pb.JWK {
+	jwk := &pb.JWK{
+		Kid: k.ID,
+		Use: "sig",
+		Alg: k.Method.Alg(),
+	}
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(pub.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(pub)
+	default:
+		return nil
+	}
+	return jwk
+}
+
+// RFC 7638 JWK thumbprint
+func thumbprint(jwk *pb.JWK) (string, error) {
+	// Required members only, in lexicographic order
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	default:
+		return "", fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return b64(sum[:]), nil
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
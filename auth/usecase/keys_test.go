@@ -0,0 +1,137 @@
+// nolint
+package usecase
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	pb "example-grpc-auth/api"
+	"example-grpc-auth/auth/repo/mock"
+	"example-grpc-auth/models"
+
+	"github.com/golang-jwt/jwt/v4"
+	mc "github.com/stretchr/testify/mock"
+)
+
+func pemKey(t *testing.T, key interface{}) []byte {
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b})
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256Key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name    string
+		method  string
+		key     interface{}
+		wantKty string
+		wantErr bool
+	}{
+		{name: "RS256", method: "RS256", key: rsaKey, wantKty: "RSA"},
+		{name: "PS256", method: "PS256", key: rsaKey, wantKty: "RSA"},
+		{name: "ES256", method: "ES256", key: p256Key, wantKty: "EC"},
+		{name: "ES384", method: "ES384", key: p384Key, wantKty: "EC"},
+		{name: "EdDSA", method: "EdDSA", key: edKey, wantKty: "OKP"},
+		{name: "curve mismatch", method: "ES256", key: p384Key, wantErr: true},
+		{name: "key type mismatch", method: "RS256", key: p256Key, wantErr: true},
+		{name: "HMAC method", method: "HS256", key: rsaKey, wantErr: true},
+		{name: "unknown method", method: "XX256", key: rsaKey, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParsePrivateKey(tt.method, pemKey(t, tt.key))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePrivateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			refreshRepo := new(mock.RefreshTokenRepoMock)
+			refreshRepo.On("CreateRefreshToken", mc.Anything).Return(nil)
+			tokenRepo := new(mock.TokenRepoMock)
+			tokenRepo.On("IsRevoked", mc.Anything).Return(false, nil)
+			tokenRepo.On("RevokedBefore", mc.Anything).Return(time.Time{}, nil)
+			s := NewAuthServer(new(mock.UserRepoMock), tokenRepo, refreshRepo, NewKeyRing(k), Options{})
+
+			// Issued token verifies with the same key
+			resp, err := s.issueTokens(context.Background(), &models.User{ID: "1", Username: "test"}, "family")
+			if err != nil {
+				t.Fatalf("issueTokens() error = %v", err)
+			}
+			got, err := s.ParseToken(context.Background(), &pb.ParseRequest{Token: resp.Token})
+			if err != nil {
+				t.Fatalf("ParseToken() error = %v", err)
+			}
+			if got.Username != "test" {
+				t.Errorf("ParseToken() = %v, want user test", got)
+			}
+
+			// Public key is published with its thumbprint
+			jwks, err := s.GetJWKS(context.Background(), &pb.JWKSRequest{})
+			if err != nil {
+				t.Fatalf("GetJWKS() error = %v", err)
+			}
+			if len(jwks.Keys) != 1 {
+				t.Fatalf("GetJWKS() = %v, want one key", jwks)
+			}
+			jwk := jwks.Keys[0]
+			if jwk.Kty != tt.wantKty || jwk.Alg != tt.method || jwk.Kid != k.ID || jwk.Kid == "" {
+				t.Errorf("GetJWKS() = %v, want kty %s alg %s kid %s", jwk, tt.wantKty, tt.method, k.ID)
+			}
+
+			// HS256 token forged with public key material must be rejected
+			forged := jwt.NewWithClaims(jwt.SigningMethodHS256, AuthClaims{Username: "admin", RegisteredClaims: jwt.RegisteredClaims{Subject: "1"}})
+			fs, err := forged.SignedString([]byte(jwk.N + jwk.X))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.ParseToken(context.Background(), &pb.ParseRequest{Token: fs}); err == nil {
+				t.Errorf("ParseToken() accepted token signed with different algorithm")
+			}
+		})
+	}
+}
+
+func TestAuthServer_GetJWKS_HMAC(t *testing.T) {
+	s := NewAuthServer(userRepo, tokenRepo, refreshRepo, NewKeyRing(NewHMACKey([]byte("123"))), Options{})
+
+	jwks, err := s.GetJWKS(context.Background(), &pb.JWKSRequest{})
+	if err != nil {
+		t.Fatalf("GetJWKS() error = %v", err)
+	}
+	if len(jwks.Keys) != 0 {
+		t.Errorf("GetJWKS() = %v, shared secret must not be published", jwks)
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	// RFC 7638 section 3.1 example
+	jwk := &pb.JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+	got, err := thumbprint(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("thumbprint() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,30 @@
+package usecase
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// Failed revocation lookups by outcome: closed when token was rejected,
+	// open when it was accepted
+	revocationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_revocation_check_errors_total",
+		Help: "Failed token revocation lookups by outcome: open or closed.",
+	}, []string{"outcome"})
+	signUps = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auth_signups_total",
+		Help: "Users signed up.",
+	})
+	// Reasons: user_not_found, invalid_credentials, error
+	signInFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_signin_failures_total",
+		Help: "Failed sign-ins by reason.",
+	}, []string{"reason"})
+	// Kinds: token for a single access token, user for all tokens of a user,
+	// refresh_family for a refresh token family
+	revocations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_revocations_total",
+		Help: "Token revocations by kind.",
+	}, []string{"kind"})
+)
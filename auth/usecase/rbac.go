@@ -0,0 +1,86 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	e "example-grpc-auth/err"
+	"example-grpc-auth/models"
+
+	pb "example-grpc-auth/api"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Add role to user. Takes effect in tokens issued after the change.
+func (s *AuthServer) AssignRole(ctx context.Context, r *pb.RoleRequest) (_ *pb.User, err error) {
+	ctx, span := startSpan(ctx, "AssignRole")
+	defer func() { endSpan(span, err) }()
+
+	return s.changeGrant(ctx, r.Token, r.UserId, r.Role, "role", s.userRepo.AddRole)
+}
+
+// Remove role from user. Takes effect in tokens issued after the change.
+func (s *AuthServer) RemoveRole(ctx context.Context, r *pb.RoleRequest) (_ *pb.User, err error) {
+	ctx, span := startSpan(ctx, "RemoveRole")
+	defer func() { endSpan(span, err) }()
+
+	return s.changeGrant(ctx, r.Token, r.UserId, r.Role, "role", s.userRepo.RemoveRole)
+}
+
+// Grant permission to user. Takes effect in tokens issued after the change.
+func (s *AuthServer) GrantPermission(ctx context.Context, r *pb.GrantRequest) (_ *pb.User, err error) {
+	ctx, span := startSpan(ctx, "GrantPermission")
+	defer func() { endSpan(span, err) }()
+
+	return s.changeGrant(ctx, r.Token, r.UserId, r.Permission, "permission", s.userRepo.AddPermission)
+}
+
+// Revoke permission from user. Takes effect in tokens issued after the change.
+func (s *AuthServer) RevokePermission(ctx context.Context, r *pb.GrantRequest) (_ *pb.User, err error) {
+	ctx, span := startSpan(ctx, "RevokePermission")
+	defer func() { endSpan(span, err) }()
+
+	return s.changeGrant(ctx, r.Token, r.UserId, r.Permission, "permission", s.userRepo.RemovePermission)
+}
+
+// Admin-only change of user's roles or permissions, kind names value in errors
+func (s *AuthServer) changeGrant(ctx context.Context, raw string, userID string, value string, kind string, change func(context.Context, string, string) (*models.User, error)) (*pb.User, error) {
+	token, err := s.authenticate(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	if !token.user.HasRole(models.RoleAdmin) {
+		return nil, status.Error(codes.PermissionDenied, e.ErrPermissionDenied.Error())
+	}
+	if userID == "" || value == "" {
+		return nil, status.Error(codes.InvalidArgument, "user id and "+kind+" are required")
+	}
+
+	user, err := change(ctx, userID, value)
+	if err != nil {
+		if errors.Is(err, e.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, err
+	}
+	return toPbUser(user), nil
+}
+
+// Check permission of token owner, for downstream services
+func (s *AuthServer) CheckPermission(ctx context.Context, r *pb.PermissionRequest) (_ *pb.PermissionResponse, err error) {
+	ctx, span := startSpan(ctx, "CheckPermission")
+	defer func() { endSpan(span, err) }()
+
+	if r.Permission == "" {
+		return nil, status.Error(codes.InvalidArgument, "permission is required")
+	}
+	token, err := s.authenticate(ctx, r.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.PermissionResponse{
+		Allowed: token.user.HasPermission(r.Permission),
+	}, nil
+}
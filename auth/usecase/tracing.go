@@ -0,0 +1,41 @@
+package usecase
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const tracerName = "example-grpc-auth/auth/usecase"
+
+// Tracer of global provider, as installed at the time of call
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Span of AuthServer method, child of gRPC server span in ctx
+func startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "AuthServer."+method)
+}
+
+// End span, marking it failed on err
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Hash password in its own span, bcrypt dominates sign-up and update latency
+func hashPassword(ctx context.Context, p string) ([]byte, error) {
+	_, span := tracer().Start(ctx, "bcrypt.GenerateFromPassword")
+	// The second argument is the cost of hashing, which we arbitrarily set as 8
+	//  (this value can be more or less, depending on the computing power you wish to utilize)
+	h, err := bcrypt.GenerateFromPassword([]byte(p), 8)
+	endSpan(span, err)
+	return h, err
+}
@@ -2,46 +2,84 @@ package usecase
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"example-grpc-auth/auth"
 	e "example-grpc-auth/err"
 	"example-grpc-auth/models"
-
+	"log"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	pb "example-grpc-auth/api"
 
 	"github.com/golang-jwt/jwt/v4"
-	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+const (
+	// Default access token lifetime
+	accessTokenTTL = 15 * time.Minute
+	// Default refresh token lifetime
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
 type AuthServer struct {
 	pb.UnimplementedAuthServiceServer
-	userRepo  auth.UserRepo
-	tokenRepo auth.TokenRepo
-	jwtKey    []byte
+	userRepo    auth.UserRepo
+	tokenRepo   auth.TokenRepo
+	refreshRepo auth.RefreshTokenRepo
+	keys        *KeyRing
+	// Replaced as a whole, so a request never sees half of reloaded options
+	opts atomic.Pointer[Options]
 }
 
-type AuthClaims struct {
-	User *models.User
-	jwt.RegisteredClaims
+func NewAuthServer(a auth.UserRepo, t auth.TokenRepo, r auth.RefreshTokenRepo, k *KeyRing, o Options) *AuthServer {
+	s := &AuthServer{
+		userRepo:    a,
+		tokenRepo:   t,
+		refreshRepo: r,
+		keys:        k,
+	}
+	s.SetOptions(o)
+	return s
 }
 
-func NewAuthServer(a auth.UserRepo, t auth.TokenRepo, b []byte) *AuthServer {
-	return &AuthServer{
-		userRepo:  a,
-		tokenRepo: t,
-		jwtKey:    b,
+// Apply options to requests started from now on. Safe for concurrent use.
+func (s *AuthServer) SetOptions(o Options) {
+	if o.AccessTTL == 0 {
+		o.AccessTTL = accessTokenTTL
+	}
+	if o.RefreshTTL == 0 {
+		o.RefreshTTL = refreshTokenTTL
 	}
+	s.opts.Store(&o)
 }
 
-func (s *AuthServer) SignUp(ctx context.Context, r *pb.SignUpRequest) (*pb.User, error) {
+// Current options. Requests load them once, defaults when never set.
+func (s *AuthServer) options() *Options {
+	if o := s.opts.Load(); o != nil {
+		return o
+	}
+	return &Options{AccessTTL: accessTokenTTL, RefreshTTL: refreshTokenTTL}
+}
+
+// Lifetime of access tokens issued now
+func (s *AuthServer) AccessTTL() time.Duration {
+	return s.options().AccessTTL
+}
+
+func (s *AuthServer) SignUp(ctx context.Context, r *pb.SignUpRequest) (_ *pb.User, err error) {
+	ctx, span := startSpan(ctx, "SignUp")
+	defer func() { endSpan(span, err) }()
+
 	// Salt and hash the password using the bcrypt algorithm
-	// The second argument is the cost of hashing, which we arbitrarily set as 8
-	//  (this value can be more or less, depending on the computing power you wish to utilize)
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(r.Password), 8)
+	hashedPassword, err := hashPassword(ctx, r.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -54,6 +92,8 @@ func (s *AuthServer) SignUp(ctx context.Context, r *pb.SignUpRequest) (*pb.User,
 		return nil, err
 	}
 
+	signUps.Inc()
+
 	resp, err := s.userRepo.GetUser(ctx, r.Username, r.Password)
 	if err != nil {
 		return nil, err
@@ -63,50 +103,241 @@ func (s *AuthServer) SignUp(ctx context.Context, r *pb.SignUpRequest) (*pb.User,
 
 }
 
-// Sign in user and get JWT string
-func (s *AuthServer) SignIn(ctx context.Context, r *pb.SignInRequest) (*pb.SignInResponce, error) {
+// Sign in user and get JWT string with refresh token
+func (s *AuthServer) SignIn(ctx context.Context, r *pb.SignInRequest) (_ *pb.SignInResponce, err error) {
+	ctx, span := startSpan(ctx, "SignIn")
+	defer func() { endSpan(span, err) }()
+
 	user, err := s.userRepo.GetUser(ctx, r.Username, r.Password)
 	if err != nil {
 		switch err {
 		case e.ErrUserNotFound:
+			signInFailures.WithLabelValues("user_not_found").Inc()
 			return nil, status.Error(codes.NotFound, e.ErrUserNotFound.Error())
 		case e.ErrInvalidCred:
+			signInFailures.WithLabelValues("invalid_credentials").Inc()
 			return nil, status.Error(codes.InvalidArgument, e.ErrInvalidCred.Error())
 		}
+		signInFailures.WithLabelValues("error").Inc()
+		return nil, err
+	}
+
+	// Each sign-in starts a new refresh token family
+	family, err := randomString()
+	if err != nil {
+		signInFailures.WithLabelValues("error").Inc()
+		return nil, err
+	}
+
+	resp, err := s.issueTokens(ctx, user, family)
+	if err != nil {
+		signInFailures.WithLabelValues("error").Inc()
+	}
+	return resp, err
+}
+
+// Exchange refresh token for a new token pair
+func (s *AuthServer) RefreshToken(ctx context.Context, r *pb.RefreshRequest) (_ *pb.SignInResponce, err error) {
+	ctx, span := startSpan(ctx, "RefreshToken")
+	defer func() { endSpan(span, err) }()
+
+	// Token is looked up read-only and consumed last, so a failure on the way
+	// leaves it usable for a retry instead of looking like reuse
+	id := hashToken(r.RefreshToken)
+	rt, err := s.refreshRepo.GetRefreshToken(ctx, id)
+	if err != nil {
+		if errors.Is(err, e.ErrInvalidRefresh) {
+			return nil, status.Error(codes.Unauthenticated, e.ErrInvalidRefresh.Error())
+		}
 		return nil, err
 	}
-	// Token expiration time:
-	exp := time.Now().Add(86400 * time.Second)
+
+	if rt.Revoked || time.Now().After(rt.ExpiresAt) {
+		return nil, status.Error(codes.Unauthenticated, e.ErrInvalidRefresh.Error())
+	}
+
+	// Token was already exchanged, so either the client or an attacker holds
+	// a stolen copy. Revoke the whole family to force a new sign-in.
+	if rt.Used {
+		return nil, s.refreshReused(ctx, rt.FamilyID)
+	}
+
+	// User signed out of all devices after the token was issued.
+	// Refresh always fails closed, it can't be served without storage anyway.
+	before, err := s.tokenRepo.RevokedBefore(ctx, rt.User.ID)
+	if err != nil {
+		revocationErrors.WithLabelValues("closed").Inc()
+		log.Printf("revocation check failed, refresh token rejected: %v", err)
+		return nil, status.Error(codes.Unavailable, e.ErrRevocationCheck.Error())
+	}
+	if rt.IssuedAt.Before(before) {
+		if err := s.revokeFamily(ctx, rt.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, status.Error(codes.Unauthenticated, e.ErrInvalidRefresh.Error())
+	}
+
+	// Pick up role and permission changes made since sign-in
+	user, err := s.userRepo.GetUserByID(ctx, rt.User.ID)
+	if err != nil {
+		if errors.Is(err, e.ErrUserNotFound) {
+			if err := s.revokeFamily(ctx, rt.FamilyID); err != nil {
+				return nil, err
+			}
+			return nil, status.Error(codes.Unauthenticated, e.ErrInvalidRefresh.Error())
+		}
+		return nil, err
+	}
+
+	resp, err := s.issueTokens(ctx, user, rt.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Concurrent exchange of the same token won the race, the new pair is
+	// in the family and gets revoked with it
+	used, err := s.refreshRepo.UseRefreshToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if used.Used {
+		return nil, s.refreshReused(ctx, rt.FamilyID)
+	}
+	return resp, nil
+}
+
+// Revoke family of reused refresh token, error for the caller
+func (s *AuthServer) refreshReused(ctx context.Context, family string) error {
+	if err := s.revokeFamily(ctx, family); err != nil {
+		return err
+	}
+	return status.Error(codes.Unauthenticated, e.ErrRefreshReused.Error())
+}
+
+// Create access token and refresh token in given family
+func (s *AuthServer) issueTokens(ctx context.Context, user *models.User, family string) (*pb.SignInResponce, error) {
+	o := s.options()
 	// Create the Claims
-	claims := AuthClaims{
-		User: user,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(exp),
-		},
+	claims, err := s.newClaims(user, o)
+	if err != nil {
+		return nil, err
 	}
-	// Create jwt Token. Signing method HS256 uses a []byte key
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	// Create jwt Token signed with active key
+	key := s.keys.Active()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
 	// Token string
-	ts, err := token.SignedString(s.jwtKey)
+	ts, err := token.SignedString(key.Private)
 	if err != nil {
 		return nil, err
 	}
 
+	// Opaque refresh token. Only its hash is stored.
+	rts, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	rt := &models.RefreshToken{
+		ID:       hashToken(rts),
+		FamilyID: family,
+		User: models.User{
+			ID:          user.ID,
+			MysqlID:     user.MysqlID,
+			Username:    user.Username,
+			Roles:       user.Roles,
+			Permissions: user.Permissions,
+		},
+		IssuedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(o.RefreshTTL),
+	}
+	if err := s.refreshRepo.CreateRefreshToken(ctx, rt); err != nil {
+		return nil, err
+	}
+
 	return &pb.SignInResponce{
-		Token: ts,
+		Token:        ts,
+		RefreshToken: rts,
+		ExpiresIn:    int64(o.AccessTTL.Seconds()),
 	}, nil
 }
 
-func (s *AuthServer) Delete(ctx context.Context, r *pb.DelRequest) (*pb.Response, error) {
+func (s *AuthServer) Delete(ctx context.Context, r *pb.DelRequest) (_ *pb.Response, err error) {
+	ctx, span := startSpan(ctx, "Delete")
+	defer func() { endSpan(span, err) }()
+
+	token, err := s.authenticate(ctx, r.Token)
+	if err != nil {
+		return nil, err
+	}
+
 	user := toModelsUser(r.User)
+	if err := authorize(token.user, user); err != nil {
+		return nil, err
+	}
 
-	if err := s.userRepo.DeleteUser(ctx, user); err != nil {
+	deleted, err := s.userRepo.DeleteUser(ctx, user)
+	if err != nil {
 		if errors.Is(err, e.ErrUserNotFound) {
 			return nil, status.Error(codes.NotFound, err.Error())
 		}
 		return nil, err
 	}
-	if err := s.tokenRepo.RevokeToken(ctx, r.Token); err != nil {
+	// Deleted user's tokens on every device, caller's own token included
+	if err := s.revokeUser(ctx, deleted.ID); err != nil {
+		return nil, err
+	}
+	return &pb.Response{
+		Response: "Ok",
+	}, nil
+}
+
+// Revoke access token and, when given, the refresh token family
+func (s *AuthServer) SignOut(ctx context.Context, r *pb.SignOutRequest) (_ *pb.Response, err error) {
+	ctx, span := startSpan(ctx, "SignOut")
+	defer func() { endSpan(span, err) }()
+
+	token, err := s.authenticate(ctx, r.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.RefreshToken != "" {
+		rt, err := s.refreshRepo.UseRefreshToken(ctx, hashToken(r.RefreshToken))
+		switch {
+		case errors.Is(err, e.ErrInvalidRefresh):
+			// Unknown refresh token, nothing to revoke
+		case err != nil:
+			return nil, err
+		case rt.User.ID != token.user.ID:
+			return nil, status.Error(codes.PermissionDenied, e.ErrPermissionDenied.Error())
+		default:
+			if err := s.revokeFamily(ctx, rt.FamilyID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := s.revoke(ctx, token); err != nil {
+		return nil, err
+	}
+	return &pb.Response{
+		Response: "Ok",
+	}, nil
+}
+
+// Revoke all access and refresh tokens of token owner.
+// Access tokens carry "iat" in whole seconds, so tokens issued
+// within the same second as sign out are rejected as well.
+func (s *AuthServer) SignOutAll(ctx context.Context, r *pb.SignOutAllRequest) (_ *pb.Response, err error) {
+	ctx, span := startSpan(ctx, "SignOutAll")
+	defer func() { endSpan(span, err) }()
+
+	token, err := s.authenticate(ctx, r.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.revokeUser(ctx, token.user.ID); err != nil {
 		return nil, err
 	}
 	return &pb.Response{
@@ -114,12 +345,28 @@ func (s *AuthServer) Delete(ctx context.Context, r *pb.DelRequest) (*pb.Response
 	}, nil
 }
 
-func (s *AuthServer) Update(ctx context.Context, r *pb.UpdRequest) (*pb.User, error) {
+func (s *AuthServer) Update(ctx context.Context, r *pb.UpdRequest) (_ *pb.User, err error) {
+	ctx, span := startSpan(ctx, "Update")
+	defer func() { endSpan(span, err) }()
+
+	token, err := s.authenticate(ctx, r.Token)
+	if err != nil {
+		return nil, err
+	}
+
 	filt := toModelsUser(r.Filtr)
 	upd := toModelsUser(r.Upd)
 
+	if err := authorize(token.user, filt); err != nil {
+		return nil, err
+	}
+	// Only admin can grant roles and permissions
+	if (len(upd.Roles) > 0 || len(upd.Permissions) > 0) && !token.user.HasRole(models.RoleAdmin) {
+		return nil, status.Error(codes.PermissionDenied, e.ErrPermissionDenied.Error())
+	}
+
 	if upd.Password != "" {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(upd.Password), 8)
+		hashedPassword, err := hashPassword(ctx, upd.Password)
 		if err != nil {
 			return nil, err
 		}
@@ -139,48 +386,202 @@ func (s *AuthServer) Update(ctx context.Context, r *pb.UpdRequest) (*pb.User, er
 		return toPbUser(user), nil
 	}
 
-	if err := s.tokenRepo.RevokeToken(ctx, r.Token); err != nil {
+	// Every session of the updated account signs in again, so a changed
+	// password locks out whoever held the old one. Admin editing another
+	// account keeps own sessions.
+	if err := s.revokeUser(ctx, user.ID); err != nil {
 		return nil, err
 	}
 
 	return toPbUser(user), nil
 }
 
-func (s *AuthServer) ParseToken(ctx context.Context, r *pb.ParseRequest) (*pb.User, error) {
-	token, err := jwt.ParseWithClaims(r.Token, &AuthClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return s.jwtKey, nil
-	})
+func (s *AuthServer) ParseToken(ctx context.Context, r *pb.ParseRequest) (_ *pb.User, err error) {
+	ctx, span := startSpan(ctx, "ParseToken")
+	defer func() { endSpan(span, err) }()
 
+	token, err := s.authenticate(ctx, r.Token)
 	if err != nil {
 		return nil, err
 	}
 
-	claims, ok := token.Claims.(*AuthClaims)
+	return toPbUser(token.user), nil
+}
+
+// Validate token and get its owner. Errors are gRPC statuses.
+func (s *AuthServer) authenticate(ctx context.Context, ts string) (*accessToken, error) {
+	o := s.options()
+	token, err := jwt.ParseWithClaims(ts, &parsedClaims{}, s.keyFunc)
+
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	claims, ok := token.Claims.(*parsedClaims)
 	if !ok || !token.Valid {
 		return nil, status.Error(codes.Unauthenticated, e.ErrInvalidAccessToken.Error())
 	}
-	// Check for revoked token
-	if ok, _ = s.tokenRepo.IsRevoked(ctx, r.Token); ok {
+	user, err := verifyClaims(claims, o)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	t := &accessToken{
+		raw:       ts,
+		jti:       tokenID(claims, ts),
+		user:      user,
+		expiresAt: claims.ExpiresAt.Time,
+	}
+	// Legacy tokens have no "iat" and are treated as issued before any user-wide revocation
+	if claims.IssuedAt != nil {
+		t.issuedAt = claims.IssuedAt.Time
+	}
+
+	revoked, err := s.isRevoked(ctx, t)
+	if err != nil {
+		if !o.RevocationFailOpen {
+			revocationErrors.WithLabelValues("closed").Inc()
+			log.Printf("revocation check failed, token rejected: %v", err)
+			return nil, status.Error(codes.Unavailable, e.ErrRevocationCheck.Error())
+		}
+		revocationErrors.WithLabelValues("open").Inc()
+		log.Printf("revocation check failed, token accepted: %v", err)
+	}
+	if revoked {
 		return nil, status.Error(codes.Unauthenticated, e.ErrInvalidAccessToken.Error())
 	}
 
-	return toPbUser(claims.User), nil
+	return t, nil
+}
+
+// Check for token revoked by jti or for the whole user
+func (s *AuthServer) isRevoked(ctx context.Context, t *accessToken) (bool, error) {
+	if ok, err := s.tokenRepo.IsRevoked(ctx, t.jti); err != nil || ok {
+		return ok, err
+	}
+	before, err := s.tokenRepo.RevokedBefore(ctx, t.user.ID)
+	if err != nil {
+		return false, err
+	}
+	return !before.IsZero() && t.issuedAt.Before(before), nil
+}
+
+func (s *AuthServer) revoke(ctx context.Context, t *accessToken) error {
+	if err := s.tokenRepo.RevokeToken(ctx, t.jti, t.expiresAt); err != nil {
+		return err
+	}
+	revocations.WithLabelValues("token").Inc()
+	return nil
+}
+
+// Revoke all access and refresh tokens of user issued until now
+func (s *AuthServer) revokeUser(ctx context.Context, userID string) error {
+	if err := s.tokenRepo.RevokeUserTokens(ctx, userID, time.Now()); err != nil {
+		return err
+	}
+	revocations.WithLabelValues("user").Inc()
+	if err := s.refreshRepo.RevokeUserFamilies(ctx, userID); err != nil {
+		return err
+	}
+	revocations.WithLabelValues("refresh_family").Inc()
+	return nil
+}
+
+func (s *AuthServer) revokeFamily(ctx context.Context, familyID string) error {
+	if err := s.refreshRepo.RevokeFamily(ctx, familyID); err != nil {
+		return err
+	}
+	revocations.WithLabelValues("refresh_family").Inc()
+	return nil
+}
+
+// Scope filter to caller's own account. Admin can act on any account
+// named by non-empty filter.
+func authorize(caller *models.User, filt *models.User) error {
+	if caller.HasRole(models.RoleAdmin) {
+		if filt.ID == "" && filt.Username == "" && filt.MysqlID == 0 {
+			return status.Error(codes.InvalidArgument, "empty user filter")
+		}
+		return nil
+	}
+
+	if filt.ID != "" && filt.ID != caller.ID {
+		return status.Error(codes.PermissionDenied, e.ErrPermissionDenied.Error())
+	}
+	if filt.ID == "" && filt.Username != "" && filt.Username != caller.Username {
+		return status.Error(codes.PermissionDenied, e.ErrPermissionDenied.Error())
+	}
+	filt.ID = caller.ID
+	return nil
+}
+
+// Verification key for token by kid header
+func (s *AuthServer) keyFunc(token *jwt.Token) (interface{}, error) {
+	// Tokens issued before key ring was introduced carry no kid
+	key := s.keys.Active()
+	if kid, ok := token.Header["kid"].(string); ok {
+		var err error
+		if key, err = s.keys.Lookup(kid); err != nil {
+			return nil, err
+		}
+	}
+	// Reject tokens signed with any other algorithm, e.g. HS256 forged with our public key
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, e.ErrInvalidAccessToken
+	}
+	return key.Public, nil
+}
+
+// Public keys in JWK Set format. HMAC keys are never published.
+func (s *AuthServer) GetJWKS(ctx context.Context, r *pb.JWKSRequest) (_ *pb.JWKS, err error) {
+	ctx, span := startSpan(ctx, "GetJWKS")
+	defer func() { endSpan(span, err) }()
+
+	jwks := &pb.JWKS{}
+	for _, k := range s.keys.Keys() {
+		if jwk := k.jwk(); jwk != nil {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	// Stable order for HTTP caches
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+	return jwks, nil
 }
 
 func toModelsUser(u *pb.User) *models.User {
+	if u == nil {
+		return &models.User{}
+	}
 	return &models.User{
-		ID:       u.Id,
-		MysqlID:  int(u.MysqlId),
-		Username: u.Username,
-		Password: u.Password,
+		ID:          u.Id,
+		MysqlID:     int(u.MysqlId),
+		Username:    u.Username,
+		Password:    u.Password,
+		Roles:       u.Roles,
+		Permissions: u.Permissions,
 	}
 }
 
+// Password hash is never sent over the wire
 func toPbUser(u *models.User) *pb.User {
 	return &pb.User{
-		Id:       u.ID,
-		MysqlId:  int64(u.MysqlID),
-		Username: u.Username,
-		Password: u.Password,
+		Id:          u.ID,
+		MysqlId:     int64(u.MysqlID),
+		Username:    u.Username,
+		Roles:       u.Roles,
+		Permissions: u.Permissions,
 	}
 }
+
+// Random 256-bit URL-safe string
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(t string) string {
+	sum := sha256.Sum256([]byte(t))
+	return hex.EncodeToString(sum[:])
+}
@@ -3,21 +3,28 @@ package usecase
 
 import (
 	"context"
+	"errors"
 	pb "example-grpc-auth/api"
+	"example-grpc-auth/auth/repo/memory"
 	"example-grpc-auth/auth/repo/mock"
+	e "example-grpc-auth/err"
 	"example-grpc-auth/models"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	mc "github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var (
-	userRepo  = new(mock.UserRepoMock)
-	tokenRepo = new(mock.TokenRepoMock)
-	server    = new(pb.UnimplementedAuthServiceServer)
+	userRepo    = new(mock.UserRepoMock)
+	tokenRepo   = new(mock.TokenRepoMock)
+	refreshRepo = new(mock.RefreshTokenRepoMock)
+	server      = new(pb.UnimplementedAuthServiceServer)
 )
 
 var testUser = &models.User{
@@ -33,6 +40,8 @@ func TestAuthServer_ParseToken(t *testing.T) {
 		userRepo                       *mock.UserRepoMock
 		tokenRepo                      *mock.TokenRepoMock
 		jwtKey                         []byte
+		audience                       string
+		legacyUntil                    time.Time
 	}
 	type args struct {
 		ctx context.Context
@@ -62,9 +71,64 @@ func TestAuthServer_ParseToken(t *testing.T) {
 			Id:       "1",
 			MysqlId:  2,
 			Username: "test",
-			Password: mc.Anything,
 		},
 		wantErr: false,
+	}, {
+		name: "legacy token in migration window",
+		fields: fields{
+			UnimplementedAuthServiceServer: server,
+			userRepo:                       userRepo,
+			tokenRepo:                      tokenRepo,
+			jwtKey:                         []byte("123"),
+			legacyUntil:                    time.Now().Add(time.Hour),
+		},
+		args: args{
+			ctx: context.Background(),
+			r: &pb.ParseRequest{
+				Token: createLegacyToken(testUser, []byte("123")),
+			},
+		},
+		// Password hash embedded in legacy token is not returned
+		want: &pb.User{
+			Id:       "1",
+			MysqlId:  2,
+			Username: "test",
+		},
+		wantErr: false,
+	}, {
+		name: "legacy token after migration window",
+		fields: fields{
+			UnimplementedAuthServiceServer: server,
+			userRepo:                       userRepo,
+			tokenRepo:                      tokenRepo,
+			jwtKey:                         []byte("123"),
+			legacyUntil:                    time.Now().Add(-time.Hour),
+		},
+		args: args{
+			ctx: context.Background(),
+			r: &pb.ParseRequest{
+				Token: createLegacyToken(testUser, []byte("123")),
+			},
+		},
+		want:    nil,
+		wantErr: true,
+	}, {
+		name: "wrong audience",
+		fields: fields{
+			UnimplementedAuthServiceServer: server,
+			userRepo:                       userRepo,
+			tokenRepo:                      tokenRepo,
+			jwtKey:                         []byte("123"),
+			audience:                       "other-service",
+		},
+		args: args{
+			ctx: context.Background(),
+			r: &pb.ParseRequest{
+				Token: createToken(testUser, []byte("123")),
+			},
+		},
+		want:    nil,
+		wantErr: true,
 	}, {
 		name: "invalid token",
 		fields: fields{
@@ -90,10 +154,12 @@ func TestAuthServer_ParseToken(t *testing.T) {
 				UnimplementedAuthServiceServer: *tt.fields.UnimplementedAuthServiceServer,
 				userRepo:                       tt.fields.userRepo,
 				tokenRepo:                      tt.fields.tokenRepo,
-				jwtKey:                         tt.fields.jwtKey,
+				keys:                           NewKeyRing(NewHMACKey(tt.fields.jwtKey)),
 			}
+			s.SetOptions(Options{Audience: tt.fields.audience, LegacyUntil: tt.fields.legacyUntil})
 
-			tt.fields.tokenRepo.On("IsRevoked", tt.args.r.Token).Return(false, nil)
+			tt.fields.tokenRepo.On("IsRevoked", mc.Anything).Return(false, nil)
+			tt.fields.tokenRepo.On("RevokedBefore", mc.Anything).Return(time.Time{}, nil)
 			got, err := s.ParseToken(tt.args.ctx, tt.args.r)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("AuthServer.ParseToken() error = %v, wantErr %v", err, tt.wantErr)
@@ -106,6 +172,76 @@ func TestAuthServer_ParseToken(t *testing.T) {
 	}
 }
 
+func TestAuthServer_ParseToken_Revoked(t *testing.T) {
+	tests := []struct {
+		name          string
+		revoked       bool
+		revokedBefore time.Time
+		wantCode      codes.Code
+	}{
+		{name: "not revoked", wantCode: codes.OK},
+		{name: "token revoked by jti", revoked: true, wantCode: codes.Unauthenticated},
+		{name: "all user tokens revoked later", revokedBefore: time.Now().Add(time.Minute), wantCode: codes.Unauthenticated},
+		{name: "user tokens revoked before issue", revokedBefore: time.Now().Add(-time.Minute), wantCode: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenRepo := new(mock.TokenRepoMock)
+			tokenRepo.On("IsRevoked", testJTI).Return(tt.revoked, nil)
+			tokenRepo.On("RevokedBefore", testUser.ID).Return(tt.revokedBefore, nil)
+			s := &AuthServer{
+				userRepo:  userRepo,
+				tokenRepo: tokenRepo,
+				keys:      NewKeyRing(NewHMACKey([]byte("123"))),
+			}
+
+			_, err := s.ParseToken(context.Background(), &pb.ParseRequest{Token: createToken(testUser, []byte("123"))})
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("AuthServer.ParseToken() error = %v, wantCode %v", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAuthServer_ParseToken_RevocationErrors(t *testing.T) {
+	lookupErr := errors.New("connection refused")
+	tests := []struct {
+		name        string
+		failOpen    bool
+		revokedErr  error
+		beforeErr   error
+		wantCode    codes.Code
+		wantCounter string
+	}{
+		{name: "token lookup fails closed", revokedErr: lookupErr, wantCode: codes.Unavailable, wantCounter: "closed"},
+		{name: "user lookup fails closed", beforeErr: lookupErr, wantCode: codes.Unavailable, wantCounter: "closed"},
+		{name: "token lookup fails open", failOpen: true, revokedErr: lookupErr, wantCode: codes.OK, wantCounter: "open"},
+		{name: "user lookup fails open", failOpen: true, beforeErr: lookupErr, wantCode: codes.OK, wantCounter: "open"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenRepo := new(mock.TokenRepoMock)
+			tokenRepo.On("IsRevoked", testJTI).Return(false, tt.revokedErr)
+			tokenRepo.On("RevokedBefore", testUser.ID).Return(time.Time{}, tt.beforeErr)
+			s := NewAuthServer(userRepo, tokenRepo, refreshRepo, NewKeyRing(NewHMACKey([]byte("123"))),
+				Options{RevocationFailOpen: tt.failOpen})
+
+			before := counterValue(tt.wantCounter)
+			_, err := s.ParseToken(context.Background(), &pb.ParseRequest{Token: createToken(testUser, []byte("123"))})
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("AuthServer.ParseToken() error = %v, wantCode %v", err, tt.wantCode)
+			}
+			if got := counterValue(tt.wantCounter) - before; got != 1 {
+				t.Errorf("revocation errors[%s] increased by %v, want 1", tt.wantCounter, got)
+			}
+		})
+	}
+}
+
+func counterValue(outcome string) float64 {
+	return testutil.ToFloat64(revocationErrors.WithLabelValues(outcome))
+}
+
 func TestAuthServer_SignUp(t *testing.T) {
 	type fields struct {
 		UnimplementedAuthServiceServer *pb.UnimplementedAuthServiceServer
@@ -140,7 +276,6 @@ func TestAuthServer_SignUp(t *testing.T) {
 		},
 		want: &pb.User{
 			Username: "test",
-			Password: mc.Anything,
 		},
 		wantErr: false,
 	},
@@ -152,7 +287,7 @@ func TestAuthServer_SignUp(t *testing.T) {
 				UnimplementedAuthServiceServer: *tt.fields.UnimplementedAuthServiceServer,
 				userRepo:                       tt.fields.userRepo,
 				tokenRepo:                      tt.fields.tokenRepo,
-				jwtKey:                         tt.fields.jwtKey,
+				keys:                           NewKeyRing(NewHMACKey(tt.fields.jwtKey)),
 			}
 
 			tt.fields.userRepo.On("CreateUser", tt.args.r.Username, tt.args.r.Password).Return(nil)
@@ -178,6 +313,7 @@ func TestAuthServer_SignIn(t *testing.T) {
 		UnimplementedAuthServiceServer pb.UnimplementedAuthServiceServer
 		userRepo                       *mock.UserRepoMock
 		tokenRepo                      *mock.TokenRepoMock
+		refreshRepo                    *mock.RefreshTokenRepoMock
 		jwtKey                         []byte
 	}
 	type args struct {
@@ -196,8 +332,9 @@ func TestAuthServer_SignIn(t *testing.T) {
 			name: "valid input",
 			fields: fields{
 				UnimplementedAuthServiceServer: *server,
-				userRepo:                       userRepo,
+				userRepo:                       new(mock.UserRepoMock),
 				tokenRepo:                      tokenRepo,
+				refreshRepo:                    refreshRepo,
 				jwtKey:                         []byte("123"),
 			},
 			args: args{
@@ -208,10 +345,9 @@ func TestAuthServer_SignIn(t *testing.T) {
 				},
 			},
 			want: &pb.User{
-				Id:       "",
+				Id:       "1",
 				MysqlId:  0,
 				Username: "test",
-				Password: mc.Anything,
 			},
 
 			wantErr: false,
@@ -223,20 +359,27 @@ func TestAuthServer_SignIn(t *testing.T) {
 				UnimplementedAuthServiceServer: tt.fields.UnimplementedAuthServiceServer,
 				userRepo:                       tt.fields.userRepo,
 				tokenRepo:                      tt.fields.tokenRepo,
-				jwtKey:                         tt.fields.jwtKey,
+				refreshRepo:                    tt.fields.refreshRepo,
+				keys:                           NewKeyRing(NewHMACKey(tt.fields.jwtKey)),
 			}
 			tt.fields.userRepo.On("GetUser", tt.args.r.Username, tt.args.r.Password).Return(&models.User{
+				ID:       "1",
 				Username: "test",
 				Password: mc.Anything,
 			}, nil)
+			tt.fields.refreshRepo.On("CreateRefreshToken", mc.Anything).Return(nil)
 			// request token
 			got1, err := s.SignIn(tt.args.ctx, tt.args.r)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("AuthServer.SignIn() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if got1.RefreshToken == "" || got1.ExpiresIn != int64(accessTokenTTL.Seconds()) {
+				t.Errorf("AuthServer.SignIn() = %v, want refresh token and expiration", got1)
+			}
 			// parse token
-			tt.fields.tokenRepo.On("IsRevoked", got1.Token).Return(false, nil)
+			tt.fields.tokenRepo.On("IsRevoked", mc.Anything).Return(false, nil)
+			tt.fields.tokenRepo.On("RevokedBefore", mc.Anything).Return(time.Time{}, nil)
 			pr := &pb.ParseRequest{
 				Token: got1.Token,
 			}
@@ -253,11 +396,265 @@ func TestAuthServer_SignIn(t *testing.T) {
 	}
 }
 
+func TestAuthServer_SignIn_FailureMetrics(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		reason string
+	}{
+		{"unknown user", e.ErrUserNotFound, "user_not_found"},
+		{"wrong password", e.ErrInvalidCred, "invalid_credentials"},
+		{"storage failure", errors.New("connection refused"), "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userRepo := new(mock.UserRepoMock)
+			userRepo.On("GetUser", "test", "pwd").Return((*models.User)(nil), tt.err)
+			s := NewAuthServer(userRepo, tokenRepo, refreshRepo, NewKeyRing(NewHMACKey([]byte("123"))), Options{})
+
+			before := testutil.ToFloat64(signInFailures.WithLabelValues(tt.reason))
+			if _, err := s.SignIn(context.Background(), &pb.SignInRequest{Username: "test", Password: "pwd"}); err == nil {
+				t.Fatal("AuthServer.SignIn() error = nil, want failure")
+			}
+			if got := testutil.ToFloat64(signInFailures.WithLabelValues(tt.reason)) - before; got != 1 {
+				t.Errorf("sign-in failures[%s] increased by %v, want 1", tt.reason, got)
+			}
+		})
+	}
+}
+
+func TestAuthServer_RefreshToken(t *testing.T) {
+	type args struct {
+		ctx context.Context
+		r   *pb.RefreshRequest
+	}
+	tests := []struct {
+		name      string
+		stored    *models.RefreshToken
+		storedErr error
+		// Current user record, nil if deleted
+		current *models.User
+		// User-wide revocation time, see SignOutAll
+		revokedBefore time.Time
+		// Exchanged by a concurrent call between lookup and use
+		raced      bool
+		args       args
+		wantCode   codes.Code
+		wantRevoke bool
+	}{{
+		name: "valid refresh token",
+		stored: &models.RefreshToken{
+			FamilyID:  "family-1",
+			User:      models.User{ID: "1", Username: "test"},
+			ExpiresAt: time.Now().Add(time.Hour),
+		},
+		// Role granted after sign-in
+		current: &models.User{ID: "1", Username: "test", Roles: []string{"editor"}},
+		args: args{
+			ctx: context.Background(),
+			r:   &pb.RefreshRequest{RefreshToken: "valid"},
+		},
+		wantCode: codes.OK,
+	}, {
+		name: "deleted user",
+		stored: &models.RefreshToken{
+			FamilyID:  "family-5",
+			User:      models.User{ID: "1", Username: "test"},
+			ExpiresAt: time.Now().Add(time.Hour),
+		},
+		current: nil,
+		args: args{
+			ctx: context.Background(),
+			r:   &pb.RefreshRequest{RefreshToken: "deleted"},
+		},
+		wantCode:   codes.Unauthenticated,
+		wantRevoke: true,
+	}, {
+		name: "reused refresh token",
+		stored: &models.RefreshToken{
+			FamilyID:  "family-2",
+			User:      models.User{ID: "1", Username: "test"},
+			ExpiresAt: time.Now().Add(time.Hour),
+			Used:      true,
+		},
+		args: args{
+			ctx: context.Background(),
+			r:   &pb.RefreshRequest{RefreshToken: "reused"},
+		},
+		wantCode:   codes.Unauthenticated,
+		wantRevoke: true,
+	}, {
+		name: "revoked family",
+		stored: &models.RefreshToken{
+			FamilyID:  "family-3",
+			User:      models.User{ID: "1", Username: "test"},
+			ExpiresAt: time.Now().Add(time.Hour),
+			Revoked:   true,
+		},
+		args: args{
+			ctx: context.Background(),
+			r:   &pb.RefreshRequest{RefreshToken: "revoked"},
+		},
+		wantCode: codes.Unauthenticated,
+	}, {
+		name: "expired refresh token",
+		stored: &models.RefreshToken{
+			FamilyID:  "family-4",
+			User:      models.User{ID: "1", Username: "test"},
+			ExpiresAt: time.Now().Add(-time.Hour),
+		},
+		args: args{
+			ctx: context.Background(),
+			r:   &pb.RefreshRequest{RefreshToken: "expired"},
+		},
+		wantCode: codes.Unauthenticated,
+	}, {
+		name: "signed out of all devices",
+		stored: &models.RefreshToken{
+			FamilyID:  "family-6",
+			User:      models.User{ID: "1", Username: "test"},
+			IssuedAt:  time.Now().Add(-time.Hour),
+			ExpiresAt: time.Now().Add(time.Hour),
+		},
+		current:       &models.User{ID: "1", Username: "test"},
+		revokedBefore: time.Now().Add(-time.Minute),
+		args: args{
+			ctx: context.Background(),
+			r:   &pb.RefreshRequest{RefreshToken: "signed-out"},
+		},
+		wantCode:   codes.Unauthenticated,
+		wantRevoke: true,
+	}, {
+		name: "exchanged concurrently",
+		stored: &models.RefreshToken{
+			FamilyID:  "family-7",
+			User:      models.User{ID: "1", Username: "test"},
+			ExpiresAt: time.Now().Add(time.Hour),
+		},
+		current: &models.User{ID: "1", Username: "test"},
+		raced:   true,
+		args: args{
+			ctx: context.Background(),
+			r:   &pb.RefreshRequest{RefreshToken: "raced"},
+		},
+		wantCode:   codes.Unauthenticated,
+		wantRevoke: true,
+	}, {
+		name:      "unknown refresh token",
+		stored:    nil,
+		storedErr: e.ErrInvalidRefresh,
+		args: args{
+			ctx: context.Background(),
+			r:   &pb.RefreshRequest{RefreshToken: "unknown"},
+		},
+		wantCode: codes.Unauthenticated,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refreshRepo := new(mock.RefreshTokenRepoMock)
+			userRepo := new(mock.UserRepoMock)
+			tokenRepo := new(mock.TokenRepoMock)
+			s := &AuthServer{
+				userRepo:    userRepo,
+				tokenRepo:   tokenRepo,
+				refreshRepo: refreshRepo,
+				keys:        NewKeyRing(NewHMACKey([]byte("123"))),
+			}
+
+			refreshRepo.On("GetRefreshToken", hashToken(tt.args.r.RefreshToken)).Return(tt.stored, tt.storedErr)
+			if tt.stored != nil {
+				before := *tt.stored
+				before.Used = before.Used || tt.raced
+				refreshRepo.On("UseRefreshToken", hashToken(tt.args.r.RefreshToken)).Return(&before, nil)
+			}
+			tokenRepo.On("RevokedBefore", "1").Return(tt.revokedBefore, nil)
+			refreshRepo.On("CreateRefreshToken", mc.Anything).Return(nil)
+			if tt.stored != nil {
+				refreshRepo.On("RevokeFamily", tt.stored.FamilyID).Return(nil)
+				if tt.current != nil {
+					userRepo.On("GetUserByID", tt.stored.User.ID).Return(tt.current, nil)
+				} else {
+					userRepo.On("GetUserByID", tt.stored.User.ID).Return(tt.current, e.ErrUserNotFound)
+				}
+			}
+
+			got, err := s.RefreshToken(tt.args.ctx, tt.args.r)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("AuthServer.RefreshToken() error = %v, wantCode %v", err, tt.wantCode)
+				return
+			}
+			if tt.wantRevoke {
+				refreshRepo.AssertCalled(t, "RevokeFamily", tt.stored.FamilyID)
+			} else {
+				refreshRepo.AssertNotCalled(t, "RevokeFamily", mc.Anything)
+			}
+			if tt.wantCode != codes.OK {
+				return
+			}
+			if got.Token == "" || got.RefreshToken == "" || got.RefreshToken == tt.args.r.RefreshToken {
+				t.Errorf("AuthServer.RefreshToken() = %v, want new token pair", got)
+			}
+			// New refresh token stays in the same family
+			refreshRepo.AssertCalled(t, "CreateRefreshToken", mc.MatchedBy(func(rt *models.RefreshToken) bool {
+				return rt.FamilyID == tt.stored.FamilyID && rt.ID == hashToken(got.RefreshToken)
+			}))
+			// Access token carries current roles
+			tokenRepo.On("IsRevoked", mc.Anything).Return(false, nil)
+			user, err := s.ParseToken(tt.args.ctx, &pb.ParseRequest{Token: got.Token})
+			if err != nil {
+				t.Fatalf("AuthServer.ParseToken() error = %v", err)
+			}
+			if !reflect.DeepEqual(user.Roles, tt.current.Roles) {
+				t.Errorf("AuthServer.ParseToken() roles = %v, want %v", user.Roles, tt.current.Roles)
+			}
+		})
+	}
+}
+
+func TestAuthServer_RefreshToken_RetryAfterUnavailable(t *testing.T) {
+	ctx := context.Background()
+	users := memory.NewUserRepo()
+	if err := users.CreateUser(ctx, "test", "hash"); err != nil {
+		t.Fatal(err)
+	}
+	user, _ := users.GetUserByID(ctx, "1")
+	refreshRepo := memory.NewRefreshTokenRepo()
+	tokenRepo := new(mock.TokenRepoMock)
+	tokenRepo.On("RevokedBefore", "1").Return(time.Time{}, errors.New("connection refused")).Once()
+	tokenRepo.On("RevokedBefore", "1").Return(time.Time{}, nil)
+	s := NewAuthServer(users, tokenRepo, refreshRepo, NewKeyRing(NewHMACKey([]byte("123"))), Options{})
+
+	tokens, err := s.issueTokens(ctx, user, "family-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.RefreshToken(ctx, &pb.RefreshRequest{RefreshToken: tokens.RefreshToken})
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("AuthServer.RefreshToken() error = %v, want Unavailable", err)
+	}
+
+	// Failed call left the token usable, the retry is not taken for reuse
+	got, err := s.RefreshToken(ctx, &pb.RefreshRequest{RefreshToken: tokens.RefreshToken})
+	if err != nil {
+		t.Fatalf("AuthServer.RefreshToken() retry error = %v", err)
+	}
+	if _, err := s.RefreshToken(ctx, &pb.RefreshRequest{RefreshToken: got.RefreshToken}); err != nil {
+		t.Errorf("AuthServer.RefreshToken() of new token error = %v, want family intact", err)
+	}
+}
+
+var adminUser = &models.User{
+	ID:       "10",
+	Username: "admin",
+	Roles:    []string{models.RoleAdmin},
+}
+
 func TestAuthServer_Delete(t *testing.T) {
 	type fields struct {
 		UnimplementedAuthServiceServer pb.UnimplementedAuthServiceServer
 		userRepo                       *mock.UserRepoMock
 		tokenRepo                      *mock.TokenRepoMock
+		refreshRepo                    *mock.RefreshTokenRepoMock
 		jwtKey                         []byte
 	}
 	type args struct {
@@ -268,51 +665,178 @@ func TestAuthServer_Delete(t *testing.T) {
 		name    string
 		fields  fields
 		args    args
-		want    *pb.Response
-		wantErr bool
+		revoked bool
+		// Filter expected to reach repository, nil if it must not be called
+		wantFilt *models.User
+		// User returned by repository, token owner by default
+		deleted *models.User
+		// User whose tokens are revoked
+		wantRevoked string
+		want        *pb.Response
+		wantCode    codes.Code
 	}{
-		// TODO: Add test cases.
 		{
-			name: "valid input",
-			fields: fields{
-				UnimplementedAuthServiceServer: *server,
-				userRepo:                       userRepo,
-				tokenRepo:                      tokenRepo,
-				jwtKey:                         []byte("123"),
-			},
+			name: "own account by username",
 			args: args{
 				ctx: context.Background(),
 				r: &pb.DelRequest{
 					User: &pb.User{
 						Username: "test",
-						Password: mc.Anything,
 					},
-					Token: mc.Anything,
+					Token: createToken(testUser, []byte("123")),
 				},
 			},
+			wantFilt:    &models.User{ID: "1", Username: "test"},
+			wantRevoked: "1",
 			want: &pb.Response{
 				Response: "Ok",
 			},
-			wantErr: false,
+			wantCode: codes.OK,
+		},
+		{
+			name: "own account without filter",
+			args: args{
+				ctx: context.Background(),
+				r: &pb.DelRequest{
+					Token: createToken(testUser, []byte("123")),
+				},
+			},
+			wantFilt:    &models.User{ID: "1"},
+			wantRevoked: "1",
+			want: &pb.Response{
+				Response: "Ok",
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name: "other account by id",
+			args: args{
+				ctx: context.Background(),
+				r: &pb.DelRequest{
+					User: &pb.User{
+						Id: "2",
+					},
+					Token: createToken(testUser, []byte("123")),
+				},
+			},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name: "other account by username",
+			args: args{
+				ctx: context.Background(),
+				r: &pb.DelRequest{
+					User: &pb.User{
+						Username: "victim",
+					},
+					Token: createToken(testUser, []byte("123")),
+				},
+			},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name: "admin deletes another user",
+			args: args{
+				ctx: context.Background(),
+				r: &pb.DelRequest{
+					User: &pb.User{
+						Username: "victim",
+					},
+					Token: createToken(adminUser, []byte("123")),
+				},
+			},
+			wantFilt: &models.User{Username: "victim"},
+			deleted:  &models.User{ID: "3", Username: "victim"},
+			// Victim is signed out everywhere, admin stays signed in
+			wantRevoked: "3",
+			want: &pb.Response{
+				Response: "Ok",
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name: "admin with empty filter",
+			args: args{
+				ctx: context.Background(),
+				r: &pb.DelRequest{
+					Token: createToken(adminUser, []byte("123")),
+				},
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "invalid token",
+			args: args{
+				ctx: context.Background(),
+				r: &pb.DelRequest{
+					User: &pb.User{
+						Username: "test",
+					},
+					Token: createToken(testUser, []byte("other key")),
+				},
+			},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name: "revoked token",
+			args: args{
+				ctx: context.Background(),
+				r: &pb.DelRequest{
+					User: &pb.User{
+						Username: "test",
+					},
+					Token: createToken(testUser, []byte("123")),
+				},
+			},
+			revoked:  true,
+			wantCode: codes.Unauthenticated,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			tt.fields = fields{
+				UnimplementedAuthServiceServer: *server,
+				userRepo:                       new(mock.UserRepoMock),
+				tokenRepo:                      new(mock.TokenRepoMock),
+				refreshRepo:                    new(mock.RefreshTokenRepoMock),
+				jwtKey:                         []byte("123"),
+			}
 			s := &AuthServer{
 				UnimplementedAuthServiceServer: tt.fields.UnimplementedAuthServiceServer,
 				userRepo:                       tt.fields.userRepo,
 				tokenRepo:                      tt.fields.tokenRepo,
-				jwtKey:                         tt.fields.jwtKey,
+				refreshRepo:                    tt.fields.refreshRepo,
+				keys:                           NewKeyRing(NewHMACKey(tt.fields.jwtKey)),
+			}
+			deleted := tt.deleted
+			if deleted == nil {
+				deleted = testUser
 			}
 
-			tt.fields.userRepo.On("DeleteUser", toModelsUser(tt.args.r.User)).Return(nil)
-			tt.fields.tokenRepo.On("RevokeToken", tt.args.r.Token).Return(nil)
+			tt.fields.tokenRepo.On("IsRevoked", mc.Anything).Return(tt.revoked, nil)
+			tt.fields.tokenRepo.On("RevokedBefore", mc.Anything).Return(time.Time{}, nil)
+			tt.fields.userRepo.On("DeleteUser", mc.Anything).Return(deleted, nil)
+			tt.fields.tokenRepo.On("RevokeUserTokens", mc.Anything, mc.Anything).Return(nil)
+			tt.fields.refreshRepo.On("RevokeUserFamilies", mc.Anything).Return(nil)
 
 			got, err := s.Delete(tt.args.ctx, tt.args.r)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("AuthServer.Delete() error = %v, wantErr %v", err, tt.wantErr)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("AuthServer.Delete() error = %v, wantCode %v", err, tt.wantCode)
 				return
 			}
+			if tt.wantFilt != nil {
+				tt.fields.userRepo.AssertCalled(t, "DeleteUser", tt.wantFilt)
+			} else {
+				tt.fields.userRepo.AssertNotCalled(t, "DeleteUser", mc.Anything)
+			}
+			if tt.wantRevoked != "" {
+				tt.fields.tokenRepo.AssertCalled(t, "RevokeUserTokens", tt.wantRevoked, mc.Anything)
+				tt.fields.refreshRepo.AssertCalled(t, "RevokeUserFamilies", tt.wantRevoked)
+			} else {
+				tt.fields.tokenRepo.AssertNotCalled(t, "RevokeUserTokens", mc.Anything, mc.Anything)
+			}
+			// Caller's token alone is never revoked, it's covered by owner's revocation
+			tt.fields.tokenRepo.AssertNotCalled(t, "RevokeToken", mc.Anything, mc.Anything)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("AuthServer.Delete() = %v, want %v", got, tt.want)
 			}
@@ -325,6 +849,7 @@ func TestAuthServer_Update(t *testing.T) {
 		UnimplementedAuthServiceServer *pb.UnimplementedAuthServiceServer
 		userRepo                       *mock.UserRepoMock
 		tokenRepo                      *mock.TokenRepoMock
+		refreshRepo                    *mock.RefreshTokenRepoMock
 		jwtKey                         []byte
 	}
 	type args struct {
@@ -335,18 +860,16 @@ func TestAuthServer_Update(t *testing.T) {
 		name    string
 		fields  fields
 		args    args
-		want    *pb.User
-		wantErr bool
+		revoked bool
+		// Filter expected to reach repository, nil if it must not be called
+		wantFilt *models.User
+		// Updated user whose tokens and refresh families are revoked
+		wantRevokedUser string
+		want            *pb.User
+		wantCode        codes.Code
 	}{
-		// TODO: Add test cases.
 		{
 			name: "valid input",
-			fields: fields{
-				UnimplementedAuthServiceServer: server,
-				userRepo:                       userRepo,
-				tokenRepo:                      tokenRepo,
-				jwtKey:                         []byte{},
-			},
 			args: args{
 				ctx: context.Background(),
 				r: &pb.UpdRequest{
@@ -354,7 +877,6 @@ func TestAuthServer_Update(t *testing.T) {
 						Id:       "1",
 						MysqlId:  0,
 						Username: "test",
-						Password: mc.Anything,
 					},
 					Upd: &pb.User{
 						Id:       "1",
@@ -362,35 +884,163 @@ func TestAuthServer_Update(t *testing.T) {
 						Username: "test1",
 						Password: "",
 					},
-					Token:  mc.Anything,
+					Token:  createToken(testUser, []byte("123")),
 					SignUp: false,
 				},
 			},
+			wantFilt:        &models.User{ID: "1", Username: "test"},
+			wantRevokedUser: "1",
 			want: &pb.User{
 				Id:       "1",
 				MysqlId:  1,
 				Username: "test1",
-				Password: mc.Anything,
 			},
-			wantErr: false,
+			wantCode: codes.OK,
+		},
+		{
+			name: "sign up case",
+			args: args{
+				ctx: context.Background(),
+				r: &pb.UpdRequest{
+					Upd: &pb.User{
+						MysqlId: 1,
+					},
+					Token:  createToken(testUser, []byte("123")),
+					SignUp: true,
+				},
+			},
+			wantFilt: &models.User{ID: "1"},
+			want: &pb.User{
+				Id:       "1",
+				MysqlId:  1,
+				Username: "test",
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name: "other account",
+			args: args{
+				ctx: context.Background(),
+				r: &pb.UpdRequest{
+					Filtr: &pb.User{
+						Username: "victim",
+					},
+					Upd: &pb.User{
+						Username: "owned",
+					},
+					Token: createToken(testUser, []byte("123")),
+				},
+			},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name: "grant self admin role",
+			args: args{
+				ctx: context.Background(),
+				r: &pb.UpdRequest{
+					Upd: &pb.User{
+						Roles: []string{models.RoleAdmin},
+					},
+					Token: createToken(testUser, []byte("123")),
+				},
+			},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name: "admin updates another user",
+			args: args{
+				ctx: context.Background(),
+				r: &pb.UpdRequest{
+					Filtr: &pb.User{
+						Id: "2",
+					},
+					Upd: &pb.User{
+						Username: "renamed",
+					},
+					Token: createToken(adminUser, []byte("123")),
+				},
+			},
+			wantFilt:        &models.User{ID: "2"},
+			wantRevokedUser: "2",
+			want: &pb.User{
+				Id:       "2",
+				Username: "renamed",
+			},
+			wantCode: codes.OK,
+		},
+		{
+			name: "missing token",
+			args: args{
+				ctx: context.Background(),
+				r: &pb.UpdRequest{
+					Filtr: &pb.User{
+						Id: "1",
+					},
+					Upd: &pb.User{
+						Username: "test1",
+					},
+				},
+			},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name: "revoked token",
+			args: args{
+				ctx: context.Background(),
+				r: &pb.UpdRequest{
+					Filtr: &pb.User{
+						Id: "1",
+					},
+					Upd: &pb.User{
+						Username: "test1",
+					},
+					Token: createToken(testUser, []byte("123")),
+				},
+			},
+			revoked:  true,
+			wantCode: codes.Unauthenticated,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			tt.fields = fields{
+				UnimplementedAuthServiceServer: server,
+				userRepo:                       new(mock.UserRepoMock),
+				tokenRepo:                      new(mock.TokenRepoMock),
+				refreshRepo:                    new(mock.RefreshTokenRepoMock),
+				jwtKey:                         []byte("123"),
+			}
 			s := &AuthServer{
 				UnimplementedAuthServiceServer: *tt.fields.UnimplementedAuthServiceServer,
 				userRepo:                       tt.fields.userRepo,
 				tokenRepo:                      tt.fields.tokenRepo,
-				jwtKey:                         tt.fields.jwtKey,
+				refreshRepo:                    tt.fields.refreshRepo,
+				keys:                           NewKeyRing(NewHMACKey(tt.fields.jwtKey)),
 			}
 
-			tt.fields.userRepo.On("UpdateUser", toModelsUser(tt.args.r.Filtr), toModelsUser(tt.args.r.Upd)).Return(toModelsUser(tt.want), nil)
-			tt.fields.tokenRepo.On("RevokeToken", tt.args.r.Token).Return(nil)
+			tt.fields.tokenRepo.On("IsRevoked", mc.Anything).Return(tt.revoked, nil)
+			tt.fields.tokenRepo.On("RevokedBefore", mc.Anything).Return(time.Time{}, nil)
+			tt.fields.userRepo.On("UpdateUser", mc.Anything, toModelsUser(tt.args.r.Upd)).Return(toModelsUser(tt.want), nil)
+			tt.fields.tokenRepo.On("RevokeUserTokens", mc.Anything, mc.Anything).Return(nil)
+			tt.fields.refreshRepo.On("RevokeUserFamilies", mc.Anything).Return(nil)
 			got, err := s.Update(tt.args.ctx, tt.args.r)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("AuthServer.Update() error = %v, wantErr %v", err, tt.wantErr)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("AuthServer.Update() error = %v, wantCode %v", err, tt.wantCode)
 				return
 			}
+			if tt.wantFilt != nil {
+				tt.fields.userRepo.AssertCalled(t, "UpdateUser", tt.wantFilt, toModelsUser(tt.args.r.Upd))
+			} else {
+				tt.fields.userRepo.AssertNotCalled(t, "UpdateUser", mc.Anything, mc.Anything)
+			}
+			// Nothing is revoked in sign up case or on failure
+			if tt.wantRevokedUser != "" {
+				tt.fields.tokenRepo.AssertCalled(t, "RevokeUserTokens", tt.wantRevokedUser, mc.Anything)
+				tt.fields.refreshRepo.AssertCalled(t, "RevokeUserFamilies", tt.wantRevokedUser)
+			} else {
+				tt.fields.tokenRepo.AssertNotCalled(t, "RevokeUserTokens", mc.Anything, mc.Anything)
+			}
+			tt.fields.tokenRepo.AssertNotCalled(t, "RevokeToken", mc.Anything, mc.Anything)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("AuthServer.Update() = %v, want %v", got, tt.want)
 			}
@@ -398,14 +1048,128 @@ func TestAuthServer_Update(t *testing.T) {
 	}
 }
 
+func TestAuthServer_SignOut(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		refresh    string
+		stored     *models.RefreshToken
+		storedErr  error
+		wantCode   codes.Code
+		wantRevoke bool
+	}{
+		{name: "access token only", token: createToken(testUser, []byte("123")), wantCode: codes.OK},
+		{
+			name:       "with refresh token",
+			token:      createToken(testUser, []byte("123")),
+			refresh:    "own",
+			stored:     &models.RefreshToken{FamilyID: "family-1", User: models.User{ID: "1"}},
+			wantCode:   codes.OK,
+			wantRevoke: true,
+		},
+		{
+			name:      "unknown refresh token",
+			token:     createToken(testUser, []byte("123")),
+			refresh:   "unknown",
+			storedErr: e.ErrInvalidRefresh,
+			wantCode:  codes.OK,
+		},
+		{
+			name:     "refresh token of other user",
+			token:    createToken(testUser, []byte("123")),
+			refresh:  "other",
+			stored:   &models.RefreshToken{FamilyID: "family-2", User: models.User{ID: "2"}},
+			wantCode: codes.PermissionDenied,
+		},
+		{name: "invalid token", token: "invalid", wantCode: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenRepo := new(mock.TokenRepoMock)
+			refreshRepo := new(mock.RefreshTokenRepoMock)
+			s := &AuthServer{
+				userRepo:    userRepo,
+				tokenRepo:   tokenRepo,
+				refreshRepo: refreshRepo,
+				keys:        NewKeyRing(NewHMACKey([]byte("123"))),
+			}
+
+			tokenRepo.On("IsRevoked", mc.Anything).Return(false, nil)
+			tokenRepo.On("RevokedBefore", mc.Anything).Return(time.Time{}, nil)
+			tokenRepo.On("RevokeToken", testJTI, mc.Anything).Return(nil)
+			refreshRepo.On("UseRefreshToken", hashToken(tt.refresh)).Return(tt.stored, tt.storedErr)
+			refreshRepo.On("RevokeFamily", mc.Anything).Return(nil)
+
+			_, err := s.SignOut(context.Background(), &pb.SignOutRequest{Token: tt.token, RefreshToken: tt.refresh})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("AuthServer.SignOut() error = %v, wantCode %v", err, tt.wantCode)
+			}
+			if tt.wantCode == codes.OK {
+				tokenRepo.AssertCalled(t, "RevokeToken", testJTI, mc.Anything)
+			} else {
+				tokenRepo.AssertNotCalled(t, "RevokeToken", mc.Anything, mc.Anything)
+			}
+			if tt.wantRevoke {
+				refreshRepo.AssertCalled(t, "RevokeFamily", tt.stored.FamilyID)
+			} else {
+				refreshRepo.AssertNotCalled(t, "RevokeFamily", mc.Anything)
+			}
+		})
+	}
+}
+
+func TestAuthServer_SignOutAll(t *testing.T) {
+	tokenRepo := new(mock.TokenRepoMock)
+	refreshRepo := new(mock.RefreshTokenRepoMock)
+	s := &AuthServer{
+		userRepo:    userRepo,
+		tokenRepo:   tokenRepo,
+		refreshRepo: refreshRepo,
+		keys:        NewKeyRing(NewHMACKey([]byte("123"))),
+	}
+	tokenRepo.On("IsRevoked", mc.Anything).Return(false, nil)
+	tokenRepo.On("RevokedBefore", mc.Anything).Return(time.Time{}, nil).Once()
+	tokenRepo.On("RevokeUserTokens", testUser.ID, mc.Anything).Return(nil)
+	refreshRepo.On("RevokeUserFamilies", testUser.ID).Return(nil)
+
+	token := createToken(testUser, []byte("123"))
+	if _, err := s.SignOutAll(context.Background(), &pb.SignOutAllRequest{Token: token}); err != nil {
+		t.Fatalf("AuthServer.SignOutAll() error = %v", err)
+	}
+	tokenRepo.AssertCalled(t, "RevokeUserTokens", testUser.ID, mc.MatchedBy(func(before time.Time) bool {
+		return !before.After(time.Now()) && before.After(time.Now().Add(-time.Minute))
+	}))
+	// Refresh tokens are revoked too, not left to the marker expiring with them
+	refreshRepo.AssertCalled(t, "RevokeUserFamilies", testUser.ID)
+
+	// The same token is rejected afterwards
+	tokenRepo.On("RevokedBefore", testUser.ID).Return(time.Now(), nil)
+	if _, err := s.ParseToken(context.Background(), &pb.ParseRequest{Token: token}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("AuthServer.ParseToken() error = %v, want Unauthenticated", err)
+	}
+
+	if _, err := s.SignOutAll(context.Background(), &pb.SignOutAllRequest{Token: "invalid"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("AuthServer.SignOutAll() error = %v, want Unauthenticated", err)
+	}
+}
+
+// Token id of tokens made by createToken
+const testJTI = "test-jti"
+
 func createToken(u *models.User, k []byte) string {
 	// Token expiration time:
 	exp := time.Now().Add(86400 * time.Second)
 	// Create the Claims
 	claims := AuthClaims{
-		User: u,
+		Username:    u.Username,
+		MysqlID:     u.MysqlID,
+		Roles:       u.Roles,
+		Permissions: u.Permissions,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   u.ID,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(exp),
+			ID:        testJTI,
 		},
 	}
 	// Create jwt Token. Signing method HS256 uses a []byte key
@@ -418,3 +1182,192 @@ func createToken(u *models.User, k []byte) string {
 
 	return ts
 }
+
+// Token in format used before standard claims, with full user embedded
+func createLegacyToken(u *models.User, k []byte) string {
+	claims := struct {
+		User *models.User
+		jwt.RegisteredClaims
+	}{
+		User: u,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(86400 * time.Second)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	ts, err := token.SignedString(k)
+	if err != nil {
+		return ""
+	}
+
+	return ts
+}
+
+func TestAuthServer_AssignRole(t *testing.T) {
+	type args struct {
+		ctx context.Context
+		r   *pb.RoleRequest
+	}
+	tests := []struct {
+		name     string
+		args     args
+		remove   bool
+		repoErr  error
+		want     *pb.User
+		wantCode codes.Code
+	}{
+		{
+			name: "admin assigns role",
+			args: args{
+				ctx: context.Background(),
+				r:   &pb.RoleRequest{Token: createToken(adminUser, []byte("123")), UserId: "1", Role: "editor"},
+			},
+			want:     &pb.User{Id: "1", Username: "test", Roles: []string{"editor"}},
+			wantCode: codes.OK,
+		},
+		{
+			name: "admin removes role",
+			args: args{
+				ctx: context.Background(),
+				r:   &pb.RoleRequest{Token: createToken(adminUser, []byte("123")), UserId: "1", Role: "editor"},
+			},
+			remove:   true,
+			want:     &pb.User{Id: "1", Username: "test"},
+			wantCode: codes.OK,
+		},
+		{
+			name: "unknown user",
+			args: args{
+				ctx: context.Background(),
+				r:   &pb.RoleRequest{Token: createToken(adminUser, []byte("123")), UserId: "404", Role: "editor"},
+			},
+			repoErr:  e.ErrUserNotFound,
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "non-admin",
+			args: args{
+				ctx: context.Background(),
+				r:   &pb.RoleRequest{Token: createToken(testUser, []byte("123")), UserId: "1", Role: models.RoleAdmin},
+			},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name: "missing role",
+			args: args{
+				ctx: context.Background(),
+				r:   &pb.RoleRequest{Token: createToken(adminUser, []byte("123")), UserId: "1"},
+			},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userRepo := new(mock.UserRepoMock)
+			tokenRepo := new(mock.TokenRepoMock)
+			s := &AuthServer{
+				userRepo:  userRepo,
+				tokenRepo: tokenRepo,
+				keys:      NewKeyRing(NewHMACKey([]byte("123"))),
+			}
+
+			tokenRepo.On("IsRevoked", mc.Anything).Return(false, nil)
+			tokenRepo.On("RevokedBefore", mc.Anything).Return(time.Time{}, nil)
+			userRepo.On("AddRole", tt.args.r.UserId, tt.args.r.Role).Return(toModelsUser(tt.want), tt.repoErr)
+			userRepo.On("RemoveRole", tt.args.r.UserId, tt.args.r.Role).Return(toModelsUser(tt.want), tt.repoErr)
+
+			var got *pb.User
+			var err error
+			if tt.remove {
+				got, err = s.RemoveRole(tt.args.ctx, tt.args.r)
+			} else {
+				got, err = s.AssignRole(tt.args.ctx, tt.args.r)
+			}
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("AuthServer.AssignRole() error = %v, wantCode %v", err, tt.wantCode)
+				return
+			}
+			if tt.wantCode == codes.OK && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AuthServer.AssignRole() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthServer_CheckPermission(t *testing.T) {
+	reader := &models.User{ID: "2", Username: "reader", Permissions: []string{"orders:read", "reports:*"}}
+
+	tests := []struct {
+		name       string
+		user       *models.User
+		permission string
+		want       bool
+		wantCode   codes.Code
+	}{
+		{name: "granted", user: reader, permission: "orders:read", want: true},
+		{name: "not granted", user: reader, permission: "orders:write", want: false},
+		{name: "wildcard", user: reader, permission: "reports:export", want: true},
+		{name: "admin", user: adminUser, permission: "orders:write", want: true},
+		{name: "no permissions", user: testUser, permission: "orders:read", want: false},
+		{name: "empty permission", user: reader, permission: "", wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenRepo := new(mock.TokenRepoMock)
+			s := &AuthServer{
+				userRepo:  new(mock.UserRepoMock),
+				tokenRepo: tokenRepo,
+				keys:      NewKeyRing(NewHMACKey([]byte("123"))),
+			}
+			token := createToken(tt.user, []byte("123"))
+			tokenRepo.On("IsRevoked", mc.Anything).Return(false, nil)
+			tokenRepo.On("RevokedBefore", mc.Anything).Return(time.Time{}, nil)
+
+			got, err := s.CheckPermission(context.Background(), &pb.PermissionRequest{Token: token, Permission: tt.permission})
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("AuthServer.CheckPermission() error = %v, wantCode %v", err, tt.wantCode)
+				return
+			}
+			if err == nil && got.Allowed != tt.want {
+				t.Errorf("AuthServer.CheckPermission() = %v, want %v", got.Allowed, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthServer_SetOptions(t *testing.T) {
+	userRepo := new(mock.UserRepoMock)
+	tokenRepo := new(mock.TokenRepoMock)
+	refreshRepo := new(mock.RefreshTokenRepoMock)
+	userRepo.On("GetUser", "test", "pwd").Return(testUser, nil)
+	tokenRepo.On("IsRevoked", mc.Anything).Return(false, nil)
+	tokenRepo.On("RevokedBefore", mc.Anything).Return(time.Time{}, nil)
+	refreshRepo.On("CreateRefreshToken", mc.Anything).Return(nil)
+	s := NewAuthServer(userRepo, tokenRepo, refreshRepo, NewKeyRing(NewHMACKey([]byte("123"))), Options{Issuer: "old"})
+
+	signIn := func() *pb.SignInResponce {
+		t.Helper()
+		got, err := s.SignIn(context.Background(), &pb.SignInRequest{Username: "test", Password: "pwd"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	old := signIn()
+	if old.ExpiresIn != int64(accessTokenTTL.Seconds()) {
+		t.Errorf("ExpiresIn = %d, want default TTL", old.ExpiresIn)
+	}
+
+	s.SetOptions(Options{AccessTTL: time.Minute, Issuer: "new"})
+	if got := signIn(); got.ExpiresIn != 60 {
+		t.Errorf("ExpiresIn = %d after SetOptions, want 60", got.ExpiresIn)
+	}
+	if got := refreshRepo.Calls[len(refreshRepo.Calls)-1].Arguments.Get(0).(*models.RefreshToken); time.Until(got.ExpiresAt) < refreshTokenTTL-time.Minute {
+		t.Errorf("refresh token expires at %v, want default TTL", got.ExpiresAt)
+	}
+	// Tokens of the old issuer are rejected by the new one
+	_, err := s.ParseToken(context.Background(), &pb.ParseRequest{Token: old.Token})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("ParseToken() of old issuer error = %v, want Unauthenticated", err)
+	}
+}
@@ -0,0 +1,180 @@
+// nolint
+package authclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	pb "example-grpc-auth/api"
+	"example-grpc-auth/auth/repo/mock"
+	"example-grpc-auth/auth/usecase"
+	"example-grpc-auth/models"
+
+	mc "github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Auth service over in-memory connection, signing with fresh ES256 key
+func startAuthService(t *testing.T) (pb.AuthServiceClient, *mock.TokenRepoMock) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+	key, err := usecase.ParsePrivateKey("ES256", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userRepo := new(mock.UserRepoMock)
+	userRepo.On("GetUser", "test", "pwd").Return(&models.User{ID: "1", Username: "test", Roles: []string{"admin"}}, nil)
+	tokenRepo := new(mock.TokenRepoMock)
+	tokenRepo.On("IsRevoked", mc.Anything).Return(false, nil)
+	tokenRepo.On("RevokedBefore", mc.Anything).Return(time.Time{}, nil)
+	refreshRepo := new(mock.RefreshTokenRepoMock)
+	refreshRepo.On("CreateRefreshToken", mc.Anything).Return(nil)
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	pb.RegisterAuthServiceServer(s, usecase.NewAuthServer(userRepo, tokenRepo, refreshRepo, usecase.NewKeyRing(key), usecase.Options{}))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewAuthServiceClient(conn), tokenRepo
+}
+
+func withToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func TestInterceptor_Unary(t *testing.T) {
+	client, tokenRepo := startAuthService(t)
+	resp, err := client.SignIn(context.Background(), &pb.SignInRequest{Username: "test", Password: "pwd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, err := NewJWKSValidator(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		validator Validator
+		ctx       context.Context
+		method    string
+		wantCode  codes.Code
+		wantUser  string
+	}{
+		{name: "remote valid token", validator: NewRemoteValidator(client), ctx: withToken(resp.Token), wantUser: "1"},
+		{name: "local valid token", validator: jwks, ctx: withToken(resp.Token), wantUser: "1"},
+		{name: "remote invalid token", validator: NewRemoteValidator(client), ctx: withToken("invalid"), wantCode: codes.Unauthenticated},
+		{name: "local invalid token", validator: jwks, ctx: withToken(resp.Token + "x"), wantCode: codes.Unauthenticated},
+		{name: "missing token", validator: jwks, ctx: context.Background(), wantCode: codes.Unauthenticated},
+		{name: "public method", validator: jwks, ctx: context.Background(), method: "/api.AuthService/SignIn"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := New(tt.validator, Options{Public: []string{"/api.AuthService/SignIn"}})
+			info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+			if tt.method != "" {
+				info.FullMethod = tt.method
+			}
+
+			var got string
+			_, err := i.Unary()(tt.ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				got = UserID(ctx)
+				if tt.wantUser != "" && !HasRole(ctx, "admin") {
+					t.Errorf("HasRole(admin) = false, want true")
+				}
+				return nil, nil
+			})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Unary() error = %v, wantCode %v", err, tt.wantCode)
+			}
+			if got != tt.wantUser {
+				t.Errorf("UserID() = %q, want %q", got, tt.wantUser)
+			}
+		})
+	}
+
+	// Second remote call with the same token is served from cache
+	i := New(NewRemoteValidator(client), Options{})
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	before := countCalls(tokenRepo, "IsRevoked")
+	for n := 0; n < 3; n++ {
+		if _, err := i.Unary()(withToken(resp.Token), nil, info, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := countCalls(tokenRepo, "IsRevoked") - before; calls != 1 {
+		t.Errorf("ParseToken called %d times, want 1", calls)
+	}
+}
+
+func countCalls(m *mock.TokenRepoMock, method string) int {
+	n := 0
+	for _, c := range m.Calls {
+		if c.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testStream) Context() context.Context { return s.ctx }
+
+func TestInterceptor_Stream(t *testing.T) {
+	client, _ := startAuthService(t)
+	resp, err := client.SignIn(context.Background(), &pb.SignInRequest{Username: "test", Password: "pwd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := New(NewRemoteValidator(client), Options{})
+	info := &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}
+
+	var got *pb.User
+	err = i.Stream()(nil, &testStream{ctx: withToken(resp.Token)}, info, func(srv interface{}, ss grpc.ServerStream) error {
+		got, _ = UserFromContext(ss.Context())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if got == nil || got.Username != "test" {
+		t.Errorf("UserFromContext() = %v, want user test", got)
+	}
+
+	err = i.Stream()(nil, &testStream{ctx: context.Background()}, info, func(srv interface{}, ss grpc.ServerStream) error {
+		t.Errorf("handler called without token")
+		return nil
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Stream() error = %v, want Unauthenticated", err)
+	}
+}
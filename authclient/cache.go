@@ -0,0 +1,84 @@
+package authclient
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	pb "example-grpc-auth/api"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Validation results cache keyed by token hash. Entry lives until cache TTL
+// or token expiration, whichever comes first.
+type cache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[[sha256.Size]byte]cacheEntry
+}
+
+type cacheEntry struct {
+	user *pb.User
+	exp  time.Time
+}
+
+func newCache(ttl time.Duration, size int) *cache {
+	return &cache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[[sha256.Size]byte]cacheEntry),
+	}
+}
+
+func (c *cache) get(token string) (*pb.User, bool) {
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.exp) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.user, true
+}
+
+func (c *cache) put(token string, u *pb.User) {
+	exp := time.Now().Add(c.ttl)
+	// Token was already validated, only expiration is needed here
+	claims := new(jwt.RegisteredClaims)
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err == nil && claims.ExpiresAt != nil {
+		if claims.ExpiresAt.Before(exp) {
+			exp = claims.ExpiresAt.Time
+		}
+	}
+
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= c.size {
+		c.evict()
+	}
+	c.entries[key] = cacheEntry{user: u, exp: exp}
+}
+
+// Drop expired entries, or everything if cache is still full
+func (c *cache) evict() {
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.exp) {
+			delete(c.entries, k)
+		}
+	}
+	if len(c.entries) >= c.size {
+		c.entries = make(map[[sha256.Size]byte]cacheEntry)
+	}
+}
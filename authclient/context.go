@@ -0,0 +1,42 @@
+package authclient
+
+import (
+	"context"
+
+	pb "example-grpc-auth/api"
+)
+
+type ctxKey struct{}
+
+// Attach authenticated user to context
+func NewContext(ctx context.Context, u *pb.User) context.Context {
+	return context.WithValue(ctx, ctxKey{}, u)
+}
+
+// Authenticated user put into context by interceptor
+func UserFromContext(ctx context.Context) (*pb.User, bool) {
+	u, ok := ctx.Value(ctxKey{}).(*pb.User)
+	return u, ok
+}
+
+// Authenticated user id, empty if request is not authenticated
+func UserID(ctx context.Context) string {
+	if u, ok := UserFromContext(ctx); ok {
+		return u.Id
+	}
+	return ""
+}
+
+// Does authenticated user have role
+func HasRole(ctx context.Context, role string) bool {
+	u, ok := UserFromContext(ctx)
+	if !ok {
+		return false
+	}
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
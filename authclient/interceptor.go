@@ -0,0 +1,133 @@
+// Package authclient authenticates incoming gRPC requests of downstream
+// services with tokens issued by AuthService.
+//
+//	v := authclient.NewRemoteValidator(pb.NewAuthServiceClient(conn))
+//	a := authclient.New(v, authclient.Options{})
+//	s := grpc.NewServer(
+//		grpc.UnaryInterceptor(a.Unary()),
+//		grpc.StreamInterceptor(a.Stream()),
+//	)
+//
+// Handlers get the caller with UserFromContext.
+package authclient
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultCacheTTL  = 30 * time.Second
+	defaultCacheSize = 10000
+)
+
+// Interceptor options
+type Options struct {
+	// How long validation result is reused, 30 seconds by default.
+	// Revoked tokens may be accepted for this long.
+	CacheTTL time.Duration
+	// Max cached tokens, 10000 by default
+	CacheSize int
+	// Full method names, e.g. "/api.AuthService/SignIn", served without token
+	Public []string
+}
+
+type Interceptor struct {
+	validator Validator
+	cache     *cache
+	public    map[string]bool
+}
+
+func New(v Validator, o Options) *Interceptor {
+	if o.CacheTTL == 0 {
+		o.CacheTTL = defaultCacheTTL
+	}
+	if o.CacheSize == 0 {
+		o.CacheSize = defaultCacheSize
+	}
+	public := make(map[string]bool, len(o.Public))
+	for _, m := range o.Public {
+		public[m] = true
+	}
+	return &Interceptor{
+		validator: v,
+		cache:     newCache(o.CacheTTL, o.CacheSize),
+		public:    public,
+	}
+}
+
+func (i *Interceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if i.public[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		ctx, err := i.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (i *Interceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if i.public[info.FullMethod] {
+			return handler(srv, ss)
+		}
+		ctx, err := i.authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// Validate bearer token from metadata and put its owner into context
+func (i *Interceptor) authenticate(ctx context.Context) (context.Context, error) {
+	token, err := bearerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if u, ok := i.cache.get(token); ok {
+		return NewContext(ctx, u), nil
+	}
+
+	u, err := i.validator.Validate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	i.cache.put(token, u)
+
+	return NewContext(ctx, u), nil
+}
+
+// Token from "authorization: Bearer <token>" metadata
+func bearerToken(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "missing metadata")
+	}
+	for _, v := range md.Get("authorization") {
+		if len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+			return strings.TrimSpace(v[7:]), nil
+		}
+	}
+	return "", status.Error(codes.Unauthenticated, "missing bearer token")
+}
+
+// Server stream with authenticated context
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
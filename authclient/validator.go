@@ -0,0 +1,244 @@
+package authclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	pb "example-grpc-auth/api"
+
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Minimal interval between JWKS refetches triggered by unknown kid
+const jwksRefreshInterval = time.Minute
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Token validator. Errors are gRPC statuses.
+type Validator interface {
+	Validate(ctx context.Context, token string) (*pb.User, error)
+}
+
+// Validates tokens by calling AuthService.ParseToken.
+// Revoked tokens are rejected, at the cost of a round trip per cache miss.
+type RemoteValidator struct {
+	client pb.AuthServiceClient
+}
+
+func NewRemoteValidator(c pb.AuthServiceClient) *RemoteValidator {
+	return &RemoteValidator{
+		client: c,
+	}
+}
+
+func (v *RemoteValidator) Validate(ctx context.Context, token string) (*pb.User, error) {
+	u, err := v.client.ParseToken(ctx, &pb.ParseRequest{Token: token})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return u, nil
+}
+
+// Validates token signature and claims locally with public keys.
+// Revocation is not checked, so revoked tokens stay valid until they expire.
+type LocalValidator struct {
+	// Expected "iss" and "aud" claims, not checked when empty. Set before use.
+	Issuer   string
+	Audience string
+
+	client pb.AuthServiceClient
+
+	mu        sync.RWMutex
+	keys      map[string]publicKey
+	lastFetch time.Time
+}
+
+type publicKey struct {
+	alg string
+	key interface{}
+}
+
+// Token claims, see usecase.AuthClaims
+type claims struct {
+	Username    string   `json:"username,omitempty"`
+	MysqlID     int      `json:"mysql_id,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Local validator with keys fetched by AuthService.GetJWKS.
+// Keys are refetched when token signed with unknown key arrives.
+func NewJWKSValidator(ctx context.Context, c pb.AuthServiceClient) (*LocalValidator, error) {
+	v := &LocalValidator{
+		client: c,
+		keys:   make(map[string]publicKey),
+	}
+	if err := v.fetch(ctx); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Local validator with single static public key for signing method alg
+func NewKeyValidator(kid string, alg string, key interface{}) *LocalValidator {
+	return &LocalValidator{
+		keys: map[string]publicKey{kid: {alg: alg, key: key}},
+	}
+}
+
+func (v *LocalValidator) Validate(ctx context.Context, token string) (*pb.User, error) {
+	c := new(claims)
+	t, err := jwt.ParseWithClaims(token, c, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		k, err := v.lookup(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != k.alg {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		return k.key, nil
+	})
+	if err != nil || !t.Valid {
+		return nil, status.Error(codes.Unauthenticated, "invalid access token")
+	}
+
+	if c.Subject == "" ||
+		(v.Issuer != "" && !c.VerifyIssuer(v.Issuer, true)) ||
+		(v.Audience != "" && !c.VerifyAudience(v.Audience, true)) {
+		return nil, status.Error(codes.Unauthenticated, "invalid access token")
+	}
+
+	return &pb.User{
+		Id:          c.Subject,
+		MysqlId:     int64(c.MysqlID),
+		Username:    c.Username,
+		Roles:       c.Roles,
+		Permissions: c.Permissions,
+	}, nil
+}
+
+func (v *LocalValidator) lookup(ctx context.Context, kid string) (publicKey, error) {
+	v.mu.RLock()
+	k, ok := v.keys[kid]
+	// Token without kid is fine while there is a single key
+	if kid == "" && len(v.keys) == 1 {
+		for _, k = range v.keys {
+			ok = true
+		}
+	}
+	stale := v.client != nil && time.Since(v.lastFetch) > jwksRefreshInterval
+	v.mu.RUnlock()
+
+	if ok {
+		return k, nil
+	}
+	// Key may have been rotated in since last fetch
+	if stale && kid != "" {
+		if err := v.fetch(ctx); err != nil {
+			return publicKey{}, err
+		}
+		v.mu.RLock()
+		k, ok = v.keys[kid]
+		v.mu.RUnlock()
+		if ok {
+			return k, nil
+		}
+	}
+	return publicKey{}, ErrUnknownKey
+}
+
+func (v *LocalValidator) fetch(ctx context.Context) error {
+	jwks, err := v.client.GetJWKS(ctx, &pb.JWKSRequest{})
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]publicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		key, err := parseJWK(jwk)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = publicKey{alg: jwk.Alg, key: key}
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.lastFetch = time.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+// Public key from JWK
+func parseJWK(jwk *pb.JWK) (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decodeInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,69 @@
+// Command dupusers reports accounts sharing a username up to case and Unicode
+// normalisation. Such accounts block the unique username index and must be
+// merged or renamed by hand. Exits with status 1 when duplicates are found.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"example-grpc-auth/auth/repo/mongodb"
+	"example-grpc-auth/config"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func main() {
+	configPath := flag.String("config", config.DefaultPath, "JSON config file, values are overridden by env vars")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+	ctx := context.Background()
+
+	clientOptions := options.Client().
+		ApplyURI(fmt.Sprintf("mongodb://%s/", cfg.MongoHost)).
+		SetAuth(options.Credential{
+			AuthMechanism: cfg.MongoCred.AuthMechanism,
+			AuthSource:    cfg.MongoCred.AuthSource,
+			Username:      cfg.MongoCred.Username,
+			Password:      cfg.MongoCred.Password,
+		})
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+
+	users := mongodb.NewUserRepo(client.Database(cfg.MongoDB))
+	dups, err := users.Duplicates(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(dups) == 0 {
+		fmt.Println("no duplicate usernames")
+		return
+	}
+
+	keys := make([]string, 0, len(dups))
+	for key := range dups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("%q:\n", key)
+		for _, u := range dups[key] {
+			fmt.Printf("\t%s\t%q\tmysql_id=%d\n", u.ID, u.Username, u.MysqlID)
+		}
+	}
+	fmt.Printf("%d duplicate usernames\n", len(dups))
+	client.Disconnect(ctx)
+	os.Exit(1)
+}
@@ -3,18 +3,24 @@ package main
 import (
 	"example-grpc-auth/config"
 	"example-grpc-auth/server"
+	"flag"
 	"log"
-	"os"
 )
 
 func main() {
-	if err := config.Init(); err != nil {
+	configPath := flag.String("config", config.DefaultPath, "JSON config file, values are overridden by env vars")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
 		log.Fatalf("%s", err.Error())
 	}
+	log.Printf("Loaded config %s: %v", cfg.Path, cfg)
 
-	app := server.NewApp()
+	app := server.NewApp(cfg)
 
-	if err := app.Run(os.Getenv("APP_PORT")); err != nil {
+	if err := app.Run(cfg.AppPort); err != nil {
 		log.Fatalf("%s", err.Error())
 	}
+	log.Println("Server stopped")
 }
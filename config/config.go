@@ -1,12 +1,19 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Env vars overriding config file values
 const (
 	mongoHost           = "MONGO_HOST"
 	mongoDB             = "MONGO_DATABASE"
@@ -15,9 +22,42 @@ const (
 	mongoCredUser       = "MONGO_CRED_USER"
 	mongoCredPass       = "MONGO_CRED__PASSWORD"
 	jwtSecret           = "JWT_SECRET"
+	jwtMethod           = "JWT_METHOD"
+	jwtPrivateKey       = "JWT_PRIVATE_KEY"
+	jwtKeysDir          = "JWT_KEYS_DIR"
+	jwtActiveKid        = "JWT_ACTIVE_KID"
+	jwtIssuer           = "JWT_ISSUER"
+	jwtAudience         = "JWT_AUDIENCE"
+	jwtLegacyUntil      = "JWT_LEGACY_UNTIL"
+	accessTTL           = "ACCESS_TTL"
+	refreshTTL          = "REFRESH_TTL"
+	shutdownTimeout     = "SHUTDOWN_TIMEOUT"
+	revocationFailOpen  = "REVOCATION_FAIL_OPEN"
+	database            = "DATABASE"
+	sqlDriver           = "SQL_DRIVER"
+	sqlDSN              = "SQL_DSN"
+	tokenStore          = "TOKEN_STORE"
+	redisAddr           = "REDIS_ADDR"
+	redisPassword       = "REDIS_PASSWORD"
+	redisDB             = "REDIS_DB"
+	tlsCertFile         = "TLS_CERT_FILE"
+	tlsKeyFile          = "TLS_KEY_FILE"
+	tlsClientCAFile     = "TLS_CLIENT_CA_FILE"
+	tracingExporter     = "TRACING_EXPORTER"
+	tracingEndpoint     = "TRACING_ENDPOINT"
+	tracingInsecure     = "TRACING_INSECURE"
+	jwksPort            = "JWKS_PORT"
+	metricsPort         = "METRICS_PORT"
 	appPort             = "APP_PORT"
 )
 
+const DefaultPath = "./config/config.json"
+
+// HS256 secret shorter than the hash output makes brute forcing tokens easier, RFC 7518 3.2
+const minSecretLen = 32
+
+const redacted = "REDACTED"
+
 type MongoCred struct {
 	AuthMechanism string `json:"authmechanism"`
 	AuthSource    string `json:"authsource"`
@@ -25,67 +65,362 @@ type MongoCred struct {
 	Password      string `json:"password"`
 }
 
-type config struct {
+type SQL struct {
+	// database/sql driver: "pgx", "mysql" or "sqlite"
+	Driver string `json:"driver"`
+	DSN    string `json:"dsn"`
+}
+
+type Redis struct {
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+}
+
+// gRPC listener TLS. Files are re-read when they change on disk.
+type TLS struct {
+	// PEM certificate chain and private key of the server, plaintext when empty
+	CertFile string `json:"certfile"`
+	KeyFile  string `json:"keyfile"`
+	// PEM CA certificates of internal services. When set, clients must present
+	// a certificate signed by one of them (mutual TLS).
+	ClientCAFile string `json:"clientcafile"`
+}
+
+// OpenTelemetry trace export
+type Tracing struct {
+	// "otlp", "stdout" for local use, or empty to disable
+	Exporter string `json:"exporter"`
+	// OTLP gRPC collector address, OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317 by default
+	Endpoint string `json:"endpoint"`
+	// Plaintext connection to collector
+	Insecure bool `json:"insecure"`
+}
+
+// Duration as string in JSON, e.g. "15m"
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		d.Duration = 0
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Service settings. JWT, TTL and revocation settings are reloaded on SIGHUP,
+// the rest is applied on start only, see RestartRequired.
+type Config struct {
+	// File the config was loaded from, empty for config built in code
+	Path string `json:"-"`
+
 	MongoHost string    `json:"mongohost"`
 	MongoCred MongoCred `json:"mongocred"`
 	MongoDB   string    `json:"mongodb"`
 	JWTSecret string    `json:"jwtsecret"`
-	AppPort   string    `json:"port"`
+	// Signing method: HS256 (default, uses JWTSecret), RS*, PS*, ES* or EdDSA
+	JWTMethod string `json:"jwtmethod"`
+	// PEM encoded private key file for asymmetric signing methods
+	JWTPrivateKey string `json:"jwtprivatekey"`
+	// Directory with rotatable signing keys, overrides single key settings above.
+	// See usecase.LoadKeyDir for file layout.
+	JWTKeysDir string `json:"jwtkeysdir"`
+	// Key id used to sign new tokens
+	JWTActiveKid string `json:"jwtactivekid"`
+	// "iss" and "aud" claims of issued tokens, checked on parsing when set
+	JWTIssuer   string `json:"jwtissuer"`
+	JWTAudience string `json:"jwtaudience"`
+	// RFC 3339 time until which tokens in the old claims format are accepted
+	JWTLegacyUntil string `json:"jwtlegacyuntil"`
+	// Token lifetimes, usecase defaults when zero
+	AccessTTL  Duration `json:"accessttl"`
+	RefreshTTL Duration `json:"refreshttl"`
+	// Users and refresh tokens storage: "mongodb" (default), "sql" or "memory"
+	Database string `json:"database"`
+	SQL      SQL    `json:"sql"`
+	// Revoked tokens storage: "mongodb", "redis", "sql" or "memory", same as Database by default
+	TokenStore string `json:"tokenstore"`
+	Redis      Redis  `json:"redis"`
+	// Accept tokens when revocation lookup fails instead of rejecting them
+	RevocationFailOpen bool `json:"revocationfailopen"`
+	// gRPC listener TLS, plaintext by default
+	TLS     TLS     `json:"tls"`
+	Tracing Tracing `json:"tracing"`
+	// Optional HTTP port for /.well-known/jwks.json
+	JWKSPort string `json:"jwksport"`
+	// Optional HTTP port for Prometheus /metrics
+	MetricsPort string `json:"metricsport"`
+	AppPort     string `json:"port"`
+	// In-flight RPCs are drained this long on SIGTERM, 25s by default
+	ShutdownTimeout Duration `json:"shutdowntimeout"`
 }
 
-var filePath = "./config/config.json"
+// Read JSON config file, apply env var overrides and validate the result
+func Load(path string) (*Config, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
 
-func Init() error {
+	c := &Config{Path: path}
+	if err := json.Unmarshal(file, c); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
 
-	configFile, err := os.ReadFile(filePath)
-	if err != nil {
-		log.Println("could not open or read config.json")
-		return err
+// Set values of env vars present, even empty ones
+func (c *Config) applyEnv() error {
+	strs := map[string]*string{
+		mongoHost:           &c.MongoHost,
+		mongoDB:             &c.MongoDB,
+		mongoCredAuthMech:   &c.MongoCred.AuthMechanism,
+		mongoCredAuthSource: &c.MongoCred.AuthSource,
+		mongoCredUser:       &c.MongoCred.Username,
+		mongoCredPass:       &c.MongoCred.Password,
+		jwtSecret:           &c.JWTSecret,
+		jwtMethod:           &c.JWTMethod,
+		jwtPrivateKey:       &c.JWTPrivateKey,
+		jwtKeysDir:          &c.JWTKeysDir,
+		jwtActiveKid:        &c.JWTActiveKid,
+		jwtIssuer:           &c.JWTIssuer,
+		jwtAudience:         &c.JWTAudience,
+		jwtLegacyUntil:      &c.JWTLegacyUntil,
+		database:            &c.Database,
+		sqlDriver:           &c.SQL.Driver,
+		sqlDSN:              &c.SQL.DSN,
+		tokenStore:          &c.TokenStore,
+		redisAddr:           &c.Redis.Addr,
+		redisPassword:       &c.Redis.Password,
+		tlsCertFile:         &c.TLS.CertFile,
+		tlsKeyFile:          &c.TLS.KeyFile,
+		tlsClientCAFile:     &c.TLS.ClientCAFile,
+		tracingExporter:     &c.Tracing.Exporter,
+		tracingEndpoint:     &c.Tracing.Endpoint,
+		jwksPort:            &c.JWKSPort,
+		metricsPort:         &c.MetricsPort,
+		appPort:             &c.AppPort,
+	}
+	for name, dst := range strs {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+
+	if v, ok := os.LookupEnv(redisDB); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", redisDB, err)
+		}
+		c.Redis.DB = n
+	}
+	for name, dst := range map[string]*Duration{accessTTL: &c.AccessTTL, refreshTTL: &c.RefreshTTL, shutdownTimeout: &c.ShutdownTimeout} {
+		if v, ok := os.LookupEnv(name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			dst.Duration = d
+		}
+	}
+	for name, dst := range map[string]*bool{revocationFailOpen: &c.RevocationFailOpen, tracingInsecure: &c.Tracing.Insecure} {
+		if v, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = b
+		}
 	}
+	return nil
+}
 
-	config := new(config)
+// HS256 secret files of keys dir shorter than minSecretLen. Unreadable dir is
+// reported when keys are loaded.
+func weakSecretFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var weak []string
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".secret" {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err == nil && len(bytes.TrimSpace(b)) < minSecretLen {
+			weak = append(weak, entry.Name())
+		}
+	}
+	return weak
+}
 
-	if err = json.Unmarshal(configFile, config); err != nil {
-		log.Println("incorrect config file")
-		return err
+// Check settings of configured backends and signing keys, all problems at once
+func (c *Config) Validate() error {
+	var errs []string
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
 	}
-	if err = os.Setenv(mongoHost, config.MongoHost); err != nil {
-		log.Printf("can't set environment variable %s", mongoHost)
-		return err
+
+	switch {
+	case c.JWTKeysDir != "":
+		for _, name := range weakSecretFiles(c.JWTKeysDir) {
+			add("jwtkeysdir %s must be at least %d bytes for HS256", name, minSecretLen)
+		}
+	case c.JWTMethod == "" || c.JWTMethod == "HS256":
+		if len(c.JWTSecret) < minSecretLen {
+			add("jwtsecret must be at least %d bytes for HS256", minSecretLen)
+		}
+	case c.JWTPrivateKey == "":
+		add("jwtprivatekey is required for %s", c.JWTMethod)
 	}
-	if err = os.Setenv(mongoCredAuthMech, config.MongoCred.AuthMechanism); err != nil {
-		log.Printf("can't set environment variable %s", mongoCredAuthMech)
-		return err
+	if c.JWTLegacyUntil != "" {
+		if _, err := time.Parse(time.RFC3339, c.JWTLegacyUntil); err != nil {
+			add("jwtlegacyuntil: %v", err)
+		}
 	}
-	if err = os.Setenv(mongoCredAuthSource, config.MongoCred.AuthSource); err != nil {
-		log.Printf("can't set environment variable %s", mongoCredAuthSource)
-		return err
+
+	if c.AccessTTL.Duration < 0 || c.RefreshTTL.Duration < 0 || c.ShutdownTimeout.Duration < 0 {
+		add("accessttl, refreshttl and shutdowntimeout can't be negative")
 	}
-	if err = os.Setenv(mongoCredUser, config.MongoCred.Username); err != nil {
-		log.Printf("can't set environment variable %s", mongoCredUser)
-		return err
+	if c.AccessTTL.Duration > 0 && c.RefreshTTL.Duration > 0 && c.AccessTTL.Duration >= c.RefreshTTL.Duration {
+		add("accessttl must be shorter than refreshttl")
 	}
 
-	if err = os.Setenv(mongoCredPass, config.MongoCred.Password); err != nil {
-		log.Printf("can't set environment variable %s", mongoCredPass)
-		return err
+	db := c.Database
+	switch db {
+	case "":
+		db = "mongodb"
+	case "mongodb", "sql", "memory":
+	default:
+		add("unknown database %q", c.Database)
+	}
+	store := c.TokenStore
+	switch store {
+	case "":
+		store = db
+	case "mongodb", "redis", "sql", "memory":
+	default:
+		add("unknown token store %q", c.TokenStore)
 	}
 
-	if err = os.Setenv(mongoDB, config.MongoDB); err != nil {
-		log.Printf("can't set environment variable %s", mongoDB)
-		return err
+	if db == "mongodb" || store == "mongodb" {
+		if c.MongoHost == "" || c.MongoDB == "" {
+			add("mongohost and mongodb are required for MongoDB storage")
+		}
+	}
+	if db == "sql" || store == "sql" {
+		if c.SQL.Driver == "" || c.SQL.DSN == "" {
+			add("sql driver and dsn are required for SQL storage")
+		}
+	}
+	if store == "redis" && c.Redis.Addr == "" {
+		add("redis addr is required for Redis token store")
 	}
-	fmt.Println(mongoDB, config.MongoDB)
 
-	if err = os.Setenv(jwtSecret, config.JWTSecret); err != nil {
-		log.Printf("can't set environment variable %s", jwtSecret)
-		return err
+	switch c.Tracing.Exporter {
+	case "", "otlp", "stdout":
+	default:
+		add("unknown tracing exporter %q", c.Tracing.Exporter)
 	}
 
-	if err = os.Setenv(appPort, config.AppPort); err != nil {
-		log.Printf("can't set environment variable %s", appPort)
-		return err
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		add("tls certfile and keyfile must be set together")
+	}
+	if c.TLS.ClientCAFile != "" && c.TLS.CertFile == "" {
+		add("tls clientcafile requires server certfile and keyfile")
 	}
 
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
 	return nil
 }
+
+// JSON names of settings applied on start only, that differ in next
+func (c *Config) RestartRequired(next *Config) []string {
+	var changed []string
+	check := func(name string, differ bool) {
+		if differ {
+			changed = append(changed, name)
+		}
+	}
+	check("mongohost", c.MongoHost != next.MongoHost)
+	check("mongocred", c.MongoCred != next.MongoCred)
+	check("mongodb", c.MongoDB != next.MongoDB)
+	check("database", c.Database != next.Database)
+	check("sql", c.SQL != next.SQL)
+	check("tokenstore", c.TokenStore != next.TokenStore)
+	check("redis", c.Redis != next.Redis)
+	// Rotated files are picked up, other paths are not
+	check("tls", c.TLS != next.TLS)
+	check("tracing", c.Tracing != next.Tracing)
+	check("jwksport", c.JWKSPort != next.JWKSPort)
+	check("metricsport", c.MetricsPort != next.MetricsPort)
+	check("port", c.AppPort != next.AppPort)
+	check("shutdowntimeout", c.ShutdownTimeout != next.ShutdownTimeout)
+	return changed
+}
+
+// Copy safe to log, with passwords and secrets replaced
+func (c Config) Redacted() Config {
+	redact := func(s *string) {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+	redact(&c.JWTSecret)
+	redact(&c.MongoCred.Password)
+	redact(&c.Redis.Password)
+	c.SQL.DSN = redactDSN(c.SQL.DSN)
+	return c
+}
+
+// Password of URL form DSN, whole DSN otherwise, e.g. "user:pwd@tcp(host)/db" of MySQL
+func redactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" || u.Opaque != "" {
+		if dsn == "" {
+			return ""
+		}
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return u.String()
+}
+
+// Redacted JSON, so logging config never leaks secrets
+func (c Config) String() string {
+	b, err := json.Marshal(c.Redacted())
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+func (c Config) GoString() string {
+	return c.String()
+}
@@ -8,5 +8,9 @@ var (
 	ErrUserNotFound       = errors.New("user not found")
 	ErrInvalidCred        = errors.New("invalid credentials")
 	ErrInvalidAccessToken = errors.New("invalid access token")
+	ErrPermissionDenied   = errors.New("permission denied")
 	ErrDupKey             = errors.New("username already in use")
+	ErrInvalidRefresh     = errors.New("invalid refresh token")
+	ErrRefreshReused      = errors.New("refresh token reuse detected")
+	ErrRevocationCheck    = errors.New("token revocation check failed")
 )
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Stored refresh token. ID is a hash of the opaque token string handed to the client,
+// FamilyID groups all tokens rotated from the same sign-in.
+type RefreshToken struct {
+	ID        string
+	FamilyID  string
+	User      User
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	Used      bool
+	Revoked   bool
+}
@@ -1,8 +1,41 @@
 package models
 
+import "strings"
+
+// Role allowed to act on any account, has all permissions
+const RoleAdmin = "admin"
+
 type User struct {
-	ID       string
-	MysqlID  int
-	Username string
-	Password string
+	ID          string
+	MysqlID     int
+	Username    string
+	Password    string
+	Roles       []string
+	Permissions []string
+}
+
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Check permission like "orders:read". Granted permission "orders:*"
+// covers everything in "orders:", "*" covers any permission.
+func (u *User) HasPermission(p string) bool {
+	if u.HasRole(RoleAdmin) {
+		return true
+	}
+	for _, g := range u.Permissions {
+		if g == p || g == "*" {
+			return true
+		}
+		if strings.HasSuffix(g, ":*") && strings.HasPrefix(p, strings.TrimSuffix(g, "*")) {
+			return true
+		}
+	}
+	return false
 }
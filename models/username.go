@@ -0,0 +1,17 @@
+package models
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Username as stored, in composed Unicode form
+func NormUsername(u string) string {
+	return norm.NFC.String(u)
+}
+
+// Usernames equal up to case and Unicode normalization have the same key.
+// Diacritics are significant: "jose" and "josé" are different users.
+func UsernameKey(u string) string {
+	return cases.Fold().String(NormUsername(u))
+}
@@ -25,8 +25,6 @@ db.createUser({
 });
 
 
-db.users.createIndex( { username: 1 } )
-
-db.createCollection('revokedTokens');
+// Collections and indexes are set up by migrations on service start
 
 db.adminCommand( { shutdown: 1 } )
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	pb "example-grpc-auth/api"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Backends are pinged this often to refresh readiness
+	healthCheckInterval = 5 * time.Second
+	healthCheckTimeout  = 2 * time.Second
+)
+
+// Services reported by health checks, "" is the whole server
+var healthServices = []string{"", pb.AuthService_ServiceDesc.ServiceName}
+
+// Standard health service, NOT_SERVING until backends answer and once
+// shutdown starts. Watch streams end on shutdown so they don't hold up draining.
+type healthServer struct {
+	*health.Server
+	interval time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newHealthServer() *healthServer {
+	h := &healthServer{Server: health.NewServer(), interval: healthCheckInterval, stop: make(chan struct{})}
+	h.set(healthpb.HealthCheckResponse_NOT_SERVING)
+	return h
+}
+
+func (h *healthServer) set(s healthpb.HealthCheckResponse_ServingStatus) {
+	for _, service := range healthServices {
+		h.SetServingStatus(service, s)
+	}
+}
+
+// Report NOT_SERVING from now on, ignoring readiness updates
+func (h *healthServer) shutdown() {
+	h.stopOnce.Do(func() {
+		h.Shutdown()
+		close(h.stop)
+	})
+}
+
+func (h *healthServer) Watch(in *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-h.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := h.Server.Watch(in, &watchStream{stream, ctx})
+	select {
+	case <-h.stop:
+		return status.Error(codes.Unavailable, "server is shutting down")
+	default:
+		return err
+	}
+}
+
+// Watch stream ending with shutdown
+type watchStream struct {
+	healthpb.Health_WatchServer
+	ctx context.Context
+}
+
+func (s *watchStream) Context() context.Context {
+	return s.ctx
+}
+
+// Refresh readiness from backend pings until ctx is done
+func (a *App) checkHealth(ctx context.Context) {
+	t := time.NewTicker(a.health.interval)
+	defer t.Stop()
+
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		err := a.store.ping(pingCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		s := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			s = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if s != last {
+			if err != nil {
+				log.Printf("Storage unreachable, reporting NOT_SERVING: %v", err)
+			} else {
+				log.Println("Storage reachable, reporting SERVING")
+			}
+			a.health.set(s)
+			last = s
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// nolint
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"example-grpc-auth/auth/repo/memory"
+	"example-grpc-auth/auth/repo/redis"
+	"example-grpc-auth/config"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func healthClient(t *testing.T, app *App) healthpb.HealthClient {
+	conn, err := dial(listenApp(t, app), insecure.NewCredentials())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+// Wait for status of service, checked until timeout
+func waitHealth(t *testing.T, client healthpb.HealthClient, service string, want healthpb.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+	var got healthpb.HealthCheckResponse_ServingStatus
+	for end := time.Now().Add(5 * time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("Check(%q) error = %v", service, err)
+		}
+		if got = resp.Status; got == want {
+			return
+		}
+	}
+	t.Fatalf("Check(%q) = %v, want %v", service, got, want)
+}
+
+func TestApp_Health(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	app := newApp(&config.Config{
+		Database:  "memory",
+		JWTSecret: "test-secret-test-secret-test-secret",
+	}, &storage{
+		users:   memory.NewUserRepo(),
+		tokens:  redis.NewTokenRepo(client, 0),
+		refresh: memory.NewRefreshTokenRepo(),
+		redis:   client,
+	})
+	app.health.interval = 10 * time.Millisecond
+
+	// Not serving before backends are checked
+	resp, err := app.health.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Check() before serving = %v, %v, want NOT_SERVING", resp, err)
+	}
+
+	hc := healthClient(t, app)
+	for _, service := range healthServices {
+		waitHealth(t, hc, service, healthpb.HealthCheckResponse_SERVING)
+	}
+
+	mr.SetError("LOADING")
+	waitHealth(t, hc, "", healthpb.HealthCheckResponse_NOT_SERVING)
+	mr.SetError("")
+	waitHealth(t, hc, "", healthpb.HealthCheckResponse_SERVING)
+
+	if _, err := hc.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Check() of unknown service error = %v, want NotFound", err)
+	}
+}
+
+func TestApp_HealthShutdown(t *testing.T) {
+	app := NewApp(&config.Config{
+		Database:  "memory",
+		JWTSecret: "test-secret-test-secret-test-secret",
+	})
+	hc := healthClient(t, app)
+	waitHealth(t, hc, "", healthpb.HealthCheckResponse_SERVING)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watch, err := hc.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("Watch() = %v, %v, want SERVING", resp, err)
+	}
+
+	shutCtx, shutCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutCancel()
+	// Open watch doesn't hold up draining
+	if err := app.Shutdown(shutCtx); err != nil {
+		t.Errorf("Shutdown() = %v, want nil", err)
+	}
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Watch() on shutdown = %v, %v, want NOT_SERVING", resp, err)
+	}
+	if _, err := watch.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("Watch() after shutdown error = %v, want Unavailable", err)
+	}
+}
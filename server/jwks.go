@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	pb "example-grpc-auth/api"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const jwksPath = "/.well-known/jwks.json"
+
+// Public signing keys over HTTP for services that can't call GetJWKS RPC.
+// Listener is public, so nothing else is served on it.
+func (a *App) newJWKSServer(port string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(jwksPath, a.handleJWKS)
+
+	return &http.Server{Addr: fmt.Sprintf(":%s", port), Handler: mux}
+}
+
+func (a *App) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	jwks, err := a.authServer.GetJWKS(r.Context(), &pb.JWKSRequest{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	b, err := protojson.Marshal(jwks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if _, err := w.Write(b); err != nil {
+		log.Println(err)
+	}
+}
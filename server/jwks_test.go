@@ -0,0 +1,35 @@
+// nolint
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example-grpc-auth/config"
+)
+
+func TestApp_JWKS(t *testing.T) {
+	app := NewApp(&config.Config{
+		Database:  "memory",
+		JWTSecret: "test-secret-test-secret-test-secret",
+	})
+	handler := app.newJWKSServer("0").Handler
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", jwksPath, nil))
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &jwks) != nil {
+		t.Errorf("GET %s = %d %s", jwksPath, rec.Code, rec.Body)
+	}
+
+	// Public listener exposes nothing but the key set
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/vars", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /debug/vars = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
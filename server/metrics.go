@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const metricsPath = "/metrics"
+
+var (
+	rpcs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_handled_total",
+		Help: "RPCs completed by method and status code.",
+	}, []string{"method", "code"})
+	rpcLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "grpc_server_handling_seconds",
+		Help: "RPC latency by method and status code.",
+		// Sign-up and sign-in are bcrypt bound, tens of milliseconds
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"method", "code"})
+)
+
+func observeRPC(method string, start time.Time, err error) {
+	code := status.Code(err).String()
+	rpcLatency.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+	rpcs.WithLabelValues(method, code).Inc()
+}
+
+func unaryMetrics(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeRPC(info.FullMethod, start, err)
+	return resp, err
+}
+
+func streamMetrics(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	observeRPC(info.FullMethod, start, err)
+	return err
+}
+
+// Prometheus metrics of RPCs, repositories and auth events
+func newMetricsServer(port string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.Handler())
+
+	return &http.Server{Addr: fmt.Sprintf(":%s", port), Handler: mux}
+}
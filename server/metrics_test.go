@@ -0,0 +1,43 @@
+// nolint
+package server
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	pb "example-grpc-auth/api"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestApp_Metrics(t *testing.T) {
+	client := startApp(t)
+	ctx := context.Background()
+
+	if _, err := client.SignUp(ctx, &pb.SignUpRequest{Username: "metrics", Password: "pwd"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := client.SignIn(ctx, &pb.SignInRequest{Username: "metrics", Password: "wrong"})
+	wantCode(t, "SignIn()", err, codes.InvalidArgument)
+
+	rec := httptest.NewRecorder()
+	newMetricsServer("0").Handler.ServeHTTP(rec, httptest.NewRequest("GET", metricsPath, nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	for _, want := range []string{
+		`grpc_server_handled_total{code="OK",method="/api.AuthService/SignUp"}`,
+		`grpc_server_handled_total{code="InvalidArgument",method="/api.AuthService/SignIn"}`,
+		`grpc_server_handling_seconds_bucket{code="OK",method="/api.AuthService/SignUp"`,
+		`grpc_server_handling_seconds_count{code="InvalidArgument",method="/api.AuthService/SignIn"}`,
+		`auth_repo_calls_total{backend="memory",method="CreateUser",result="ok"}`,
+		`auth_signups_total`,
+		`auth_signin_failures_total{reason="invalid_credentials"}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"example-grpc-auth/config"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// Re-read config file on SIGHUP. New active key signs new tokens, keys removed
+// from config, including a replaced jwtsecret, keep verifying tokens issued
+// before reload until those expire and are retired then. Token options apply
+// to requests started after reload.
+func (a *App) reloadOnSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-sig:
+		}
+		restart, err := a.reload()
+		if err != nil {
+			log.Printf("config reload failed, keeping current settings: %v", err)
+			continue
+		}
+		if len(restart) > 0 {
+			log.Printf("config changes of %s require restart, not applied", strings.Join(restart, ", "))
+		}
+	}
+}
+
+// Apply reloadable settings, all or nothing. Returns changed settings
+// that are applied on start only.
+func (a *App) reload() ([]string, error) {
+	if a.cfg.Path == "" {
+		return nil, errors.New("config was not loaded from file")
+	}
+	cfg, err := config.Load(a.cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Everything is loaded before anything is applied
+	active, keys, err := loadSigningKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := tokenOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens signed with dropped keys were issued with current lifetime
+	if err := a.keys.Sync(active.ID, keys, a.authServer.AccessTTL()); err != nil {
+		return nil, err
+	}
+	// Revocation markers outlive refresh tokens issued from now on
+	a.store.reload(cfg)
+	a.authServer.SetOptions(opts)
+	log.Printf("Config reloaded: active kid:%s", active.ID)
+
+	return a.cfg.RestartRequired(cfg), nil
+}
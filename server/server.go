@@ -4,109 +4,321 @@ import (
 	"context"
 	pb "example-grpc-auth/api"
 	"example-grpc-auth/auth/repo/mongodb"
+	"example-grpc-auth/auth/repo/mongodb/migrations"
 	"example-grpc-auth/auth/usecase"
+	"example-grpc-auth/config"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
-type key string
-
-// Context config keys
-const (
-	mongoHost           = key("mongoHost")
-	mongoUsername       = key("mongoUsername")
-	mongoPwd            = key("mongoPwd")
-	mongoDB             = key("mongoDB")
-	mongoCredAuthMech   = key("mongoCredAuthMech")
-	mongoCredAuthSource = key("mongoCredAuthSource")
-	jwtKey              = key("jwtKey")
-)
-
 const (
 	mongoURI = "mongodb://%s/?maxPoolSize=20&w=majority"
+	// Below default Kubernetes termination grace period of 30s
+	defaultShutdownTimeout = 25 * time.Second
+	storageCloseTimeout    = 5 * time.Second
+	// Storage connection on start
+	connectTimeout = 2 * time.Second
+	// Migrations and cache warm up on start, they go over whole collections
+	migrateTimeout = 10 * time.Minute
 )
 
 type App struct {
+	// Config the app was started with
+	cfg        *config.Config
 	authServer *usecase.AuthServer
-}
+	keys       *usecase.KeyRing
+	store      *storage
+	// Set when TLS is configured
+	certs  *certReloader
+	grpc   *grpc.Server
+	health *healthServer
+	// Set when JWKS port is configured
+	jwks *http.Server
+	// Set when metrics port is configured
+	metrics *http.Server
+	// Set when trace exporter is configured
+	tracer *sdktrace.TracerProvider
 
-func NewApp() *App {
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
-	defer cancel()
+	// Background work, until shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	start  sync.Once
+}
 
-	ctx = context.WithValue(ctx, mongoHost, os.Getenv("MONGO_HOST"))
-	ctx = context.WithValue(ctx, mongoUsername, os.Getenv("MONGO_CRED_USER"))
-	ctx = context.WithValue(ctx, mongoPwd, os.Getenv("MONGO_CRED__PASSWORD"))
-	ctx = context.WithValue(ctx, mongoDB, os.Getenv("MONGO_DATABASE"))
-	ctx = context.WithValue(ctx, mongoCredAuthMech, os.Getenv("MONGO_CRED_AUTH_MECH"))
-	ctx = context.WithValue(ctx, mongoCredAuthSource, os.Getenv("MONGO_CRED_AUTH_SOURCE"))
-	ctx = context.WithValue(ctx, jwtKey, os.Getenv("JWT_SECRET"))
+func NewApp(cfg *config.Config) *App {
+	return newApp(cfg, initStorage(context.Background(), cfg))
+}
 
-	mongoDB := initMongoDB(ctx)
+func newApp(cfg *config.Config, store *storage) *App {
+	active, keys, err := loadSigningKeys(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keyRing := usecase.NewKeyRing(active, keys...)
 
-	userRepo := mongodb.NewUserRepo(mongoDB)
-	tokenRepo := mongodb.NewTokenRepo(mongoDB)
+	opts, err := tokenOptions(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	return &App{
+	a := &App{
+		cfg: cfg,
 		authServer: usecase.NewAuthServer(
-			userRepo,
-			tokenRepo,
-			[]byte(ctx.Value(jwtKey).(string))),
+			store.users,
+			store.tokens,
+			store.refresh,
+			keyRing,
+			opts),
+		keys:  keyRing,
+		store: store,
+	}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+
+	if a.tracer, err = initTracing(context.Background(), cfg.Tracing); err != nil {
+		log.Fatal(err)
+	}
+
+	// Trace context is extracted from incoming metadata
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), unaryMetrics),
+		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor(), streamMetrics),
+	}
+	if cfg.TLS.CertFile != "" {
+		if a.certs, err = newCertReloader(cfg.TLS); err != nil {
+			log.Fatal(err)
+		}
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(a.certs.tlsConfig())))
+		if cfg.TLS.ClientCAFile != "" {
+			log.Println("TLS enabled, client certificates required")
+		} else {
+			log.Println("TLS enabled")
+		}
+	}
+	a.grpc = grpc.NewServer(serverOpts...)
+	pb.RegisterAuthServiceServer(a.grpc, a.authServer)
+	a.health = newHealthServer()
+	healthpb.RegisterHealthServer(a.grpc, a.health)
+	// Register response service
+	reflection.Register(a.grpc)
+
+	if cfg.JWKSPort != "" {
+		a.jwks = a.newJWKSServer(cfg.JWKSPort)
+	}
+	if cfg.MetricsPort != "" {
+		a.metrics = newMetricsServer(cfg.MetricsPort)
+	}
+	return a
+}
+
+func tokenOptions(cfg *config.Config) (usecase.Options, error) {
+	opts := usecase.Options{
+		AccessTTL:          cfg.AccessTTL.Duration,
+		RefreshTTL:         cfg.RefreshTTL.Duration,
+		Issuer:             cfg.JWTIssuer,
+		Audience:           cfg.JWTAudience,
+		RevocationFailOpen: cfg.RevocationFailOpen,
+	}
+	if cfg.JWTLegacyUntil != "" {
+		t, err := time.Parse(time.RFC3339, cfg.JWTLegacyUntil)
+		if err != nil {
+			return opts, fmt.Errorf("invalid legacy token deadline: %w", err)
+		}
+		opts.LegacyUntil = t
+		log.Printf("Legacy format tokens accepted until %s", t)
+	}
+	if opts.RevocationFailOpen {
+		log.Println("Tokens are accepted when revocation check fails")
+	}
+	return opts, nil
+}
+
+// Load active signing key and the rest of verification keys
+func loadSigningKeys(cfg *config.Config) (*usecase.SigningKey, []*usecase.SigningKey, error) {
+	if dir := cfg.JWTKeysDir; dir != "" {
+		keys, err := usecase.LoadKeyDir(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		kid := cfg.JWTActiveKid
+		for _, k := range keys {
+			// Single key in directory is active by default
+			if k.ID == kid || (kid == "" && len(keys) == 1) {
+				log.Printf("Loaded %d signing keys from %s: active kid:%s", len(keys), dir, k.ID)
+				return k, keys, nil
+			}
+		}
+		return nil, nil, fmt.Errorf("active signing key %q not found in %s", kid, dir)
 	}
+
+	method := cfg.JWTMethod
+	if method == "" || method == "HS256" {
+		k := usecase.NewHMACKey([]byte(cfg.JWTSecret))
+		return k, []*usecase.SigningKey{k}, nil
+	}
+
+	k, err := usecase.LoadPrivateKey(method, cfg.JWTPrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("Loaded %s signing key: kid:%s", method, k.ID)
+	return k, []*usecase.SigningKey{k}, nil
 }
 
-func initMongoDB(ctx context.Context) *mongo.Database {
-	uri := fmt.Sprintf(
-		mongoURI,
-		ctx.Value(mongoHost).(string))
+func initMongoDB(ctx context.Context, cfg *config.Config) *mongo.Database {
+	uri := fmt.Sprintf(mongoURI, cfg.MongoHost)
 
 	log.Println(uri)
 
 	clientCred := options.Credential{
-		AuthMechanism: ctx.Value(mongoCredAuthMech).(string),
-		AuthSource:    ctx.Value(mongoCredAuthSource).(string),
-		Username:      ctx.Value(mongoUsername).(string),
-		Password:      ctx.Value(mongoPwd).(string),
+		AuthMechanism: cfg.MongoCred.AuthMechanism,
+		AuthSource:    cfg.MongoCred.AuthSource,
+		Username:      cfg.MongoCred.Username,
+		Password:      cfg.MongoCred.Password,
 	}
-	clientOptions := options.Client().ApplyURI(uri).SetAuth(clientCred)
+	clientOptions := options.Client().ApplyURI(uri).SetAuth(clientCred).SetMonitor(mongodb.Monitor())
 
-	client, err := mongo.Connect(ctx, clientOptions)
+	connectCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	client, err := mongo.Connect(connectCtx, clientOptions)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	// Ping the primary
-	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+	if err := client.Ping(connectCtx, readpref.Primary()); err != nil {
 		log.Fatal(err)
 	}
 
-	log.Printf("Successfully connected to MongoDB: host:%s db:%s", ctx.Value(mongoHost), ctx.Value(mongoDB))
-	return client.Database(ctx.Value(mongoDB).(string))
+	log.Printf("Successfully connected to MongoDB: host:%s db:%s", cfg.MongoHost, cfg.MongoDB)
+	db := client.Database(cfg.MongoDB)
+
+	migrateCtx, cancel := context.WithTimeout(ctx, migrateTimeout)
+	defer cancel()
+	// Duplicate usernames fail unique index migration, see cmd/dupusers
+	if err := migrations.Run(migrateCtx, db); err != nil {
+		log.Fatalf("MongoDB migrations: %v", err)
+	}
+	return db
 }
 
+// Serve on port until SIGTERM or SIGINT, then shut down gracefully
 func (a *App) Run(port string) error {
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	log.Printf("RPC server listen on ")
-	s := grpc.NewServer()
+	log.Printf("RPC server listen on :%s", port)
 
-	pb.RegisterAuthServiceServer(s, a.authServer)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
-	// Register response service
-	reflection.Register(s)
+	served := make(chan error, 1)
+	go func() { served <- a.Serve(lis) }()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	// Second signal kills the process
+	stop()
+
+	timeout := a.cfg.ShutdownTimeout.Duration
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+	log.Printf("Shutting down, draining RPCs for up to %s", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := a.Shutdown(ctx); err != nil {
+		return err
+	}
+	return <-served
+}
+
+// Serve RPC on listener, e.g. bufconn one in tests. Returns nil after Shutdown.
+func (a *App) Serve(lis net.Listener) error {
+	a.start.Do(func() {
+		go a.reloadOnSignal()
+		go a.store.run(a.ctx)
+		go a.checkHealth(a.ctx)
+
+		if a.jwks != nil {
+			log.Printf("JWKS server listen on %s%s", a.jwks.Addr, jwksPath)
+			go listenAndServe("JWKS", a.jwks)
+		}
+		if a.metrics != nil {
+			log.Printf("Metrics server listen on %s%s", a.metrics.Addr, metricsPath)
+			go listenAndServe("Metrics", a.metrics)
+		}
+	})
+
+	return a.grpc.Serve(lis)
+}
+
+func listenAndServe(name string, srv *http.Server) {
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("%s server stopped: %v", name, err)
+	}
+}
 
-	return s.Serve(lis)
+// Stop accepting RPCs and wait for in-flight ones until ctx is done, when the
+// rest is cancelled. Background work is stopped and storage disconnected after.
+func (a *App) Shutdown(ctx context.Context) error {
+	// Load balancers stop routing here while draining
+	a.health.shutdown()
+
+	var err error
+	stopped := make(chan struct{})
+	go func() {
+		a.grpc.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Println("Shutdown deadline exceeded, cancelling in-flight RPCs")
+		a.grpc.Stop()
+		<-stopped
+		err = ctx.Err()
+	}
+
+	for _, srv := range []*http.Server{a.jwks, a.metrics} {
+		if srv != nil && srv.Shutdown(ctx) != nil {
+			srv.Close()
+		}
+	}
+	a.cancel()
+
+	// Own deadline, ctx may be over already
+	closeCtx, cancel := context.WithTimeout(context.Background(), storageCloseTimeout)
+	defer cancel()
+	if closeErr := a.store.close(closeCtx); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	// Flush spans of the drained RPCs
+	if a.tracer != nil {
+		if traceErr := a.tracer.Shutdown(closeCtx); traceErr != nil && err == nil {
+			err = traceErr
+		}
+	}
+	return err
 }
@@ -0,0 +1,312 @@
+// nolint
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	pb "example-grpc-auth/api"
+	"example-grpc-auth/auth/repo/memory"
+	"example-grpc-auth/config"
+
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// App with in-memory storage over in-memory connection
+func startApp(t *testing.T) pb.AuthServiceClient {
+	return serveApp(t, NewApp(&config.Config{
+		Database:  "memory",
+		JWTSecret: "test-secret-test-secret-test-secret",
+		JWTIssuer: "test",
+	}))
+}
+
+func serveApp(t *testing.T, app *App) pb.AuthServiceClient {
+	conn, err := dial(listenApp(t, app), insecure.NewCredentials())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewAuthServiceClient(conn)
+}
+
+func listenApp(t *testing.T, app *App) *bufconn.Listener {
+	lis := bufconn.Listen(1 << 20)
+	go app.Serve(lis)
+	t.Cleanup(func() { lis.Close() })
+	return lis
+}
+
+func dial(lis *bufconn.Listener, creds credentials.TransportCredentials) (*grpc.ClientConn, error) {
+	return grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(creds))
+}
+
+func wantCode(t *testing.T, op string, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("%s error = %v, want %v", op, err, code)
+	}
+}
+
+func TestApp_SignUpSignIn(t *testing.T) {
+	client := startApp(t)
+	ctx := context.Background()
+
+	user, err := client.SignUp(ctx, &pb.SignUpRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignUp()", err, codes.OK)
+	if user.Id == "" || user.Username != "alice" || user.Password != "" {
+		t.Errorf("SignUp() = %v", user)
+	}
+	_, err = client.SignUp(ctx, &pb.SignUpRequest{Username: "alice", Password: "other"})
+	wantCode(t, "SignUp() duplicate", err, codes.AlreadyExists)
+
+	_, err = client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "wrong"})
+	wantCode(t, "SignIn() wrong password", err, codes.InvalidArgument)
+	_, err = client.SignIn(ctx, &pb.SignInRequest{Username: "bob", Password: "pwd"})
+	wantCode(t, "SignIn() unknown user", err, codes.NotFound)
+
+	tokens, err := client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignIn()", err, codes.OK)
+	parsed, err := client.ParseToken(ctx, &pb.ParseRequest{Token: tokens.Token})
+	wantCode(t, "ParseToken()", err, codes.OK)
+	if parsed.Id != user.Id {
+		t.Errorf("ParseToken() id = %q, want %q", parsed.Id, user.Id)
+	}
+
+	// Password change revokes the token used
+	_, err = client.Update(ctx, &pb.UpdRequest{Token: tokens.Token, Upd: &pb.User{Password: "new"}})
+	wantCode(t, "Update()", err, codes.OK)
+	_, err = client.ParseToken(ctx, &pb.ParseRequest{Token: tokens.Token})
+	wantCode(t, "ParseToken() after Update", err, codes.Unauthenticated)
+	_, err = client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "new"})
+	wantCode(t, "SignIn() with new password", err, codes.OK)
+}
+
+func TestApp_RefreshAndSignOut(t *testing.T) {
+	client := startApp(t)
+	ctx := context.Background()
+
+	_, err := client.SignUp(ctx, &pb.SignUpRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignUp()", err, codes.OK)
+	first, err := client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignIn()", err, codes.OK)
+
+	second, err := client.RefreshToken(ctx, &pb.RefreshRequest{RefreshToken: first.RefreshToken})
+	wantCode(t, "RefreshToken()", err, codes.OK)
+	// Reuse of exchanged refresh token revokes the family
+	_, err = client.RefreshToken(ctx, &pb.RefreshRequest{RefreshToken: first.RefreshToken})
+	wantCode(t, "RefreshToken() reuse", err, codes.Unauthenticated)
+	_, err = client.RefreshToken(ctx, &pb.RefreshRequest{RefreshToken: second.RefreshToken})
+	wantCode(t, "RefreshToken() after reuse", err, codes.Unauthenticated)
+
+	third, err := client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignIn()", err, codes.OK)
+	_, err = client.SignOut(ctx, &pb.SignOutRequest{Token: third.Token, RefreshToken: third.RefreshToken})
+	wantCode(t, "SignOut()", err, codes.OK)
+	_, err = client.ParseToken(ctx, &pb.ParseRequest{Token: third.Token})
+	wantCode(t, "ParseToken() after SignOut", err, codes.Unauthenticated)
+	_, err = client.RefreshToken(ctx, &pb.RefreshRequest{RefreshToken: third.RefreshToken})
+	wantCode(t, "RefreshToken() after SignOut", err, codes.Unauthenticated)
+
+	// Sign out of all devices
+	a, err := client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignIn()", err, codes.OK)
+	b, err := client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignIn()", err, codes.OK)
+	_, err = client.SignOutAll(ctx, &pb.SignOutAllRequest{Token: a.Token})
+	wantCode(t, "SignOutAll()", err, codes.OK)
+	_, err = client.ParseToken(ctx, &pb.ParseRequest{Token: b.Token})
+	wantCode(t, "ParseToken() after SignOutAll", err, codes.Unauthenticated)
+	_, err = client.RefreshToken(ctx, &pb.RefreshRequest{RefreshToken: b.RefreshToken})
+	wantCode(t, "RefreshToken() after SignOutAll", err, codes.Unauthenticated)
+}
+
+func TestApp_Roles(t *testing.T) {
+	client := startApp(t)
+	ctx := context.Background()
+
+	alice, err := client.SignUp(ctx, &pb.SignUpRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignUp()", err, codes.OK)
+	bob, err := client.SignUp(ctx, &pb.SignUpRequest{Username: "bob", Password: "pwd"})
+	wantCode(t, "SignUp()", err, codes.OK)
+
+	tokens, err := client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignIn()", err, codes.OK)
+	_, err = client.AssignRole(ctx, &pb.RoleRequest{Token: tokens.Token, UserId: alice.Id, Role: "admin"})
+	wantCode(t, "AssignRole() by non-admin", err, codes.PermissionDenied)
+	_, err = client.Delete(ctx, &pb.DelRequest{Token: tokens.Token, User: &pb.User{Id: bob.Id}})
+	wantCode(t, "Delete() other user", err, codes.PermissionDenied)
+
+	_, err = client.Delete(ctx, &pb.DelRequest{Token: tokens.Token, User: &pb.User{Id: alice.Id}})
+	wantCode(t, "Delete() own account", err, codes.OK)
+	_, err = client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignIn() deleted user", err, codes.NotFound)
+}
+
+func TestApp_Permissions(t *testing.T) {
+	users := memory.NewUserRepo()
+	client := serveApp(t, newApp(&config.Config{
+		Database:  "memory",
+		JWTSecret: "test-secret-test-secret-test-secret",
+	}, &storage{users: users, tokens: memory.NewTokenRepo(), refresh: memory.NewRefreshTokenRepo()}))
+	ctx := context.Background()
+
+	admin, err := client.SignUp(ctx, &pb.SignUpRequest{Username: "admin", Password: "pwd"})
+	wantCode(t, "SignUp()", err, codes.OK)
+	if _, err := users.AddRole(ctx, admin.Id, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	alice, err := client.SignUp(ctx, &pb.SignUpRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignUp()", err, codes.OK)
+	adminTokens, err := client.SignIn(ctx, &pb.SignInRequest{Username: "admin", Password: "pwd"})
+	wantCode(t, "SignIn() admin", err, codes.OK)
+	aliceTokens, err := client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignIn() alice", err, codes.OK)
+
+	_, err = client.GrantPermission(ctx, &pb.GrantRequest{Token: aliceTokens.Token, UserId: alice.Id, Permission: "orders:read"})
+	wantCode(t, "GrantPermission() by non-admin", err, codes.PermissionDenied)
+	_, err = client.GrantPermission(ctx, &pb.GrantRequest{Token: adminTokens.Token, UserId: alice.Id})
+	wantCode(t, "GrantPermission() without permission", err, codes.InvalidArgument)
+
+	got, err := client.GrantPermission(ctx, &pb.GrantRequest{Token: adminTokens.Token, UserId: alice.Id, Permission: "orders:read"})
+	wantCode(t, "GrantPermission()", err, codes.OK)
+	if !reflect.DeepEqual(got.Permissions, []string{"orders:read"}) {
+		t.Errorf("GrantPermission() permissions = %v", got.Permissions)
+	}
+
+	// Permissions are carried by tokens issued after the grant
+	aliceTokens, err = client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignIn() after grant", err, codes.OK)
+	resp, err := client.CheckPermission(ctx, &pb.PermissionRequest{Token: aliceTokens.Token, Permission: "orders:read"})
+	wantCode(t, "CheckPermission()", err, codes.OK)
+	if !resp.Allowed {
+		t.Errorf("CheckPermission() granted = false, want true")
+	}
+	resp, err = client.CheckPermission(ctx, &pb.PermissionRequest{Token: aliceTokens.Token, Permission: "orders:write"})
+	wantCode(t, "CheckPermission()", err, codes.OK)
+	if resp.Allowed {
+		t.Errorf("CheckPermission() not granted = true, want false")
+	}
+
+	_, err = client.RevokePermission(ctx, &pb.GrantRequest{Token: adminTokens.Token, UserId: alice.Id, Permission: "orders:read"})
+	wantCode(t, "RevokePermission()", err, codes.OK)
+	aliceTokens, err = client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignIn() after revoke", err, codes.OK)
+	resp, err = client.CheckPermission(ctx, &pb.PermissionRequest{Token: aliceTokens.Token, Permission: "orders:read"})
+	wantCode(t, "CheckPermission() after revoke", err, codes.OK)
+	if resp.Allowed {
+		t.Errorf("CheckPermission() after revoke = true, want false")
+	}
+}
+
+func TestApp_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(`{"database": "memory", "jwtsecret": "old-secret-old-secret-old-secret-old"}`)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := NewApp(cfg)
+	client := serveApp(t, app)
+	ctx := context.Background()
+
+	_, err = client.SignUp(ctx, &pb.SignUpRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignUp()", err, codes.OK)
+	old, err := client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignIn()", err, codes.OK)
+
+	// Invalid config changes nothing
+	writeConfig(`{"database": "memory", "jwtsecret": "weak", "accessttl": "1m"}`)
+	if _, err := app.reload(); err == nil {
+		t.Error("reload() of invalid config succeeded")
+	}
+	_, err = client.ParseToken(ctx, &pb.ParseRequest{Token: old.Token})
+	wantCode(t, "ParseToken() after failed reload", err, codes.OK)
+
+	writeConfig(`{
+		"database": "sql", "sql": {"driver": "sqlite", "dsn": "auth.db"},
+		"jwtsecret": "new-secret-new-secret-new-secret-new", "accessttl": "1m"
+	}`)
+	restart, err := app.reload()
+	if err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	if !reflect.DeepEqual(restart, []string{"database", "sql"}) {
+		t.Errorf("reload() restart = %v, want database and sql", restart)
+	}
+
+	// Storage is kept, new TTL and key apply
+	tokens, err := client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignIn() after reload", err, codes.OK)
+	if tokens.ExpiresIn != 60 {
+		t.Errorf("SignIn() ExpiresIn = %d after reload, want 60", tokens.ExpiresIn)
+	}
+	_, err = client.ParseToken(ctx, &pb.ParseRequest{Token: tokens.Token})
+	wantCode(t, "ParseToken() after reload", err, codes.OK)
+	// Replaced secret verifies tokens issued before reload until they expire
+	_, err = client.ParseToken(ctx, &pb.ParseRequest{Token: old.Token})
+	wantCode(t, "ParseToken() signed with replaced secret", err, codes.OK)
+}
+
+func TestApp_ReloadSecretRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(`{"database": "memory", "jwtsecret": "old-secret-old-secret-old-secret-old"}`)
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := NewApp(cfg)
+	client := serveApp(t, app)
+	ctx := context.Background()
+	kid := func(token string) string {
+		parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Header["kid"].(string)
+	}
+
+	_, err = client.SignUp(ctx, &pb.SignUpRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignUp()", err, codes.OK)
+	old, err := client.SignIn(ctx, &pb.SignInRequest{Username: "alice", Password: "pwd"})
+	wantCode(t, "SignIn()", err, codes.OK)
+
+	writeConfig(`{"database": "memory", "jwtsecret": "new-secret-new-secret-new-secret-new"}`)
+	if _, err := app.reload(); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+
+	// Signed-in users keep working without signing in again
+	_, err = client.ParseToken(ctx, &pb.ParseRequest{Token: old.Token})
+	wantCode(t, "ParseToken() issued before rotation", err, codes.OK)
+	refreshed, err := client.RefreshToken(ctx, &pb.RefreshRequest{RefreshToken: old.RefreshToken})
+	wantCode(t, "RefreshToken() issued before rotation", err, codes.OK)
+	if kid(refreshed.Token) == kid(old.Token) {
+		t.Errorf("RefreshToken() signed with replaced secret %s", kid(old.Token))
+	}
+	_, err = client.ParseToken(ctx, &pb.ParseRequest{Token: refreshed.Token})
+	wantCode(t, "ParseToken() refreshed", err, codes.OK)
+}
@@ -0,0 +1,111 @@
+// nolint
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	pb "example-grpc-auth/api"
+	"example-grpc-auth/auth"
+	"example-grpc-auth/auth/repo/memory"
+	"example-grpc-auth/config"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Users repo blocking sign-ups until released or cancelled
+type blockingUsers struct {
+	auth.UserRepo
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingUsers) CreateUser(c context.Context, u string, p string) error {
+	r.entered <- struct{}{}
+	select {
+	case <-r.release:
+	case <-c.Done():
+		return c.Err()
+	}
+	return r.UserRepo.CreateUser(c, u, p)
+}
+
+func newBlockingApp(t *testing.T) (*App, *blockingUsers) {
+	users := &blockingUsers{
+		UserRepo: memory.NewUserRepo(),
+		entered:  make(chan struct{}, 1),
+		release:  make(chan struct{}),
+	}
+	app := newApp(&config.Config{
+		Database:  "memory",
+		JWTSecret: "test-secret-test-secret-test-secret",
+	}, &storage{users: users, tokens: memory.NewTokenRepo(), refresh: memory.NewRefreshTokenRepo()})
+	return app, users
+}
+
+// SignUp in flight, its error is sent when it returns
+func signUpInFlight(t *testing.T, app *App, users *blockingUsers) <-chan error {
+	client := serveApp(t, app)
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.SignUp(context.Background(), &pb.SignUpRequest{Username: "alice", Password: "pwd"})
+		done <- err
+	}()
+	select {
+	case <-users.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SignUp not started")
+	}
+	return done
+}
+
+func TestApp_Shutdown(t *testing.T) {
+	app, users := newBlockingApp(t)
+	done := signUpInFlight(t, app, users)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shut := make(chan error, 1)
+	go func() { shut <- app.Shutdown(ctx) }()
+
+	// Draining, not returned before in-flight RPC
+	select {
+	case err := <-shut:
+		t.Fatalf("Shutdown() = %v before in-flight RPC finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(users.release)
+	if err := <-done; err != nil {
+		t.Errorf("in-flight SignUp() error = %v, want nil", err)
+	}
+	if err := <-shut; err != nil {
+		t.Errorf("Shutdown() = %v, want nil", err)
+	}
+	if app.ctx.Err() == nil {
+		t.Error("background work not stopped")
+	}
+}
+
+func TestApp_ShutdownDeadline(t *testing.T) {
+	app, users := newBlockingApp(t)
+	done := signUpInFlight(t, app, users)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := app.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	select {
+	case err := <-done:
+		if status.Code(err) == codes.OK {
+			t.Error("in-flight SignUp() succeeded after forced stop")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight SignUp() not cancelled")
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"example-grpc-auth/auth"
+	"example-grpc-auth/auth/repo/cache"
+	"example-grpc-auth/auth/repo/memory"
+	"example-grpc-auth/auth/repo/metrics"
+	"example-grpc-auth/auth/repo/mongodb"
+	"example-grpc-auth/auth/repo/redis"
+	sqlrepo "example-grpc-auth/auth/repo/sql"
+	"example-grpc-auth/config"
+	"fmt"
+	"log"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	goredis "github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	_ "modernc.org/sqlite"
+)
+
+// Expired tokens are deleted this often from backends without TTL indexes
+const purgeInterval = time.Hour
+
+// Backend deleting expired tokens on demand
+type purger interface {
+	PurgeExpired(c context.Context) error
+}
+
+// Repositories of configured backends
+type storage struct {
+	users   auth.UserRepo
+	tokens  auth.TokenRepo
+	refresh auth.RefreshTokenRepo
+
+	// Set for backends needing background work or closing
+	purgers     []purger
+	revocations *cache.TokenRepo
+	redisTokens *redis.TokenRepo
+	sqlDB       *sqlrepo.DB
+	mongoDB     *mongo.Database
+	redis       *goredis.Client
+}
+
+func initStorage(ctx context.Context, cfg *config.Config) *storage {
+	s := new(storage)
+
+	db := cfg.Database
+	switch db {
+	case "", "mongodb":
+		db = "mongodb"
+		s.mongoDB = initMongoDB(ctx, cfg)
+		s.users = mongodb.NewUserRepo(s.mongoDB)
+		s.refresh = mongodb.NewRefreshTokenRepo(s.mongoDB)
+	case "sql":
+		s.sqlDB = initSQL(ctx, cfg)
+		s.users = sqlrepo.NewUserRepo(s.sqlDB)
+		s.refresh = sqlrepo.NewRefreshTokenRepo(s.sqlDB)
+	case "memory":
+		log.Println("Using in-memory storage, data is lost on restart")
+		s.users = memory.NewUserRepo()
+		memRefresh := memory.NewRefreshTokenRepo()
+		s.refresh = memRefresh
+		s.purgers = append(s.purgers, memRefresh)
+	default:
+		log.Fatalf("unknown database %q", db)
+	}
+
+	store := cfg.TokenStore
+	if store == "" {
+		store = db
+	}
+	switch store {
+	case "mongodb":
+		if s.mongoDB == nil {
+			s.mongoDB = initMongoDB(ctx, cfg)
+		}
+		mongoTokens := mongodb.NewTokenRepo(s.mongoDB)
+		// Revocation checks served from memory, Mongo is hit on possible matches only.
+		// Metered behind the cache, so metrics show Mongo calls.
+		s.revocations = cache.NewTokenRepo(metrics.NewTokenRepo(mongoTokens, store), mongoTokens, cache.Options{})
+		warmCtx, cancel := context.WithTimeout(ctx, migrateTimeout)
+		err := s.revocations.Warm(warmCtx)
+		cancel()
+		if err != nil {
+			log.Printf("revocation cache warm up failed, checking Mongo until it succeeds: %v", err)
+		}
+		s.tokens = s.revocations
+	case "redis":
+		s.redis = initRedis(ctx, cfg)
+		// User markers expire with refresh tokens, see reload
+		s.redisTokens = redis.NewTokenRepo(s.redis, cfg.RefreshTTL.Duration)
+		s.tokens = s.redisTokens
+	case "sql":
+		if s.sqlDB == nil {
+			s.sqlDB = initSQL(ctx, cfg)
+		}
+		s.tokens = sqlrepo.NewTokenRepo(s.sqlDB)
+	case "memory":
+		memTokens := memory.NewTokenRepo()
+		s.tokens = memTokens
+		s.purgers = append(s.purgers, memTokens)
+	default:
+		log.Fatalf("unknown token store %q", store)
+	}
+
+	if s.sqlDB != nil {
+		s.purgers = append(s.purgers, s.sqlDB)
+	}
+
+	s.users = metrics.NewUserRepo(s.users, db)
+	if s.revocations == nil {
+		s.tokens = metrics.NewTokenRepo(s.tokens, store)
+	}
+	return s
+}
+
+// Apply reloaded settings backends depend on
+func (s *storage) reload(cfg *config.Config) {
+	if s.redisTokens != nil {
+		s.redisTokens.SetUserTTL(cfg.RefreshTTL.Duration)
+	}
+}
+
+// Background maintenance until ctx is done
+func (s *storage) run(ctx context.Context) {
+	if s.revocations != nil {
+		go s.revocations.Run(ctx)
+	}
+	if len(s.purgers) == 0 {
+		return
+	}
+
+	t := time.NewTicker(purgeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			for _, p := range s.purgers {
+				if err := p.PurgeExpired(ctx); err != nil {
+					log.Printf("expired tokens purge failed: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// Check configured databases answer, nil for in-memory storage
+func (s *storage) ping(ctx context.Context) error {
+	if s.mongoDB != nil {
+		if err := s.mongoDB.Client().Ping(ctx, readpref.Primary()); err != nil {
+			return fmt.Errorf("mongodb: %w", err)
+		}
+	}
+	if s.sqlDB != nil {
+		if err := s.sqlDB.PingContext(ctx); err != nil {
+			return fmt.Errorf("sql: %w", err)
+		}
+	}
+	if s.redis != nil {
+		if err := s.redis.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("redis: %w", err)
+		}
+	}
+	return nil
+}
+
+// Disconnect from databases, after background work is stopped
+func (s *storage) close(ctx context.Context) error {
+	var errs []error
+	if s.mongoDB != nil {
+		errs = append(errs, s.mongoDB.Client().Disconnect(ctx))
+	}
+	if s.sqlDB != nil {
+		errs = append(errs, s.sqlDB.Close())
+	}
+	if s.redis != nil {
+		errs = append(errs, s.redis.Close())
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func initSQL(ctx context.Context, cfg *config.Config) *sqlrepo.DB {
+	driver := cfg.SQL.Driver
+	// Open applies migrations
+	migrateCtx, cancel := context.WithTimeout(ctx, migrateTimeout)
+	defer cancel()
+	db, err := sqlrepo.Open(migrateCtx, driver, cfg.SQL.DSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+	connectCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	if err := db.PingContext(connectCtx); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Successfully connected to SQL database: driver:%s", driver)
+	return db
+}
+
+func initRedis(ctx context.Context, cfg *config.Config) *goredis.Client {
+	client := goredis.NewClient(&goredis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+	connectCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	if err := client.Ping(connectCtx).Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Successfully connected to Redis: addr:%s db:%d", cfg.Redis.Addr, cfg.Redis.DB)
+	return client
+}
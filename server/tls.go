@@ -0,0 +1,132 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"example-grpc-auth/config"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Certificate files are checked for changes this often, on handshakes
+const certCheckInterval = 30 * time.Second
+
+// Server TLS config re-read from files rotated on disk.
+// Failed reload keeps serving the previous certificates.
+type certReloader struct {
+	files    config.TLS
+	interval time.Duration
+
+	mu        sync.Mutex
+	current   *tls.Config
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(files config.TLS) (*certReloader, error) {
+	r := &certReloader{files: files, interval: certCheckInterval}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Config for grpc credentials, handshakes get the latest certificates
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.config(), nil
+		},
+	}
+}
+
+func (r *certReloader) config() *tls.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) >= r.interval {
+		r.lastCheck = time.Now()
+		if r.changed() {
+			if err := r.loadLocked(); err != nil {
+				log.Printf("TLS certificates reload failed, keeping current ones: %v", err)
+			} else {
+				log.Println("TLS certificates reloaded")
+			}
+		}
+	}
+	return r.current
+}
+
+func (r *certReloader) paths() []string {
+	paths := []string{r.files.CertFile, r.files.KeyFile}
+	if r.files.ClientCAFile != "" {
+		paths = append(paths, r.files.ClientCAFile)
+	}
+	return paths
+}
+
+// Modification time of any file differs from the loaded one
+func (r *certReloader) changed() bool {
+	for n, path := range r.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Mid rotation, or gone: keep current
+			return false
+		}
+		if !info.ModTime().Equal(r.modTimes[n]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *certReloader) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadLocked()
+}
+
+func (r *certReloader) loadLocked() error {
+	// Times before reading, so files changed while loading are read again
+	var modTimes []time.Time
+	for _, path := range r.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		modTimes = append(modTimes, info.ModTime())
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load server certificate: %w", err)
+	}
+	c := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		// Clients enforce ALPN, it's not set by grpc credentials on per client configs
+		NextProtos: []string{"h2"},
+	}
+
+	if r.files.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.files.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("load client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("load client CA: no certificates found")
+		}
+		c.ClientCAs = pool
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.current = c
+	r.modTimes = modTimes
+	return nil
+}
@@ -0,0 +1,234 @@
+// nolint
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "example-grpc-auth/api"
+	"example-grpc-auth/config"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var serial int64
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// PEM certificate and key signed by ca
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Server certificate files signed by ca, client CA file when clientCA is set
+func writeTLSFiles(t *testing.T, ca *testCA, clientCA *testCA) config.TLS {
+	dir := t.TempDir()
+	files := config.TLS{
+		CertFile: filepath.Join(dir, "server.crt"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+	}
+	cert, key := ca.issue(t, "localhost", x509.ExtKeyUsageServerAuth)
+	writeFile(t, files.CertFile, cert)
+	writeFile(t, files.KeyFile, key)
+	if clientCA != nil {
+		files.ClientCAFile = filepath.Join(dir, "clients.crt")
+		writeFile(t, files.ClientCAFile, clientCA.pem)
+	}
+	return files
+}
+
+func clientCreds(t *testing.T, ca *testCA, certs ...tls.Certificate) credentials.TransportCredentials {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	return credentials.NewTLS(&tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: certs})
+}
+
+func clientCert(t *testing.T, ca *testCA) tls.Certificate {
+	certPEM, keyPEM := ca.issue(t, "orders", x509.ExtKeyUsageClientAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newTLSApp(t *testing.T, files config.TLS) *App {
+	return NewApp(&config.Config{
+		Database:  "memory",
+		JWTSecret: "test-secret-test-secret-test-secret",
+		TLS:       files,
+	})
+}
+
+// Code of SignUp call over connection with creds
+func signUpCode(t *testing.T, app *App, creds credentials.TransportCredentials) codes.Code {
+	conn, err := dial(listenApp(t, app), creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = pb.NewAuthServiceClient(conn).SignUp(ctx, &pb.SignUpRequest{Username: t.Name(), Password: "pwd"})
+	return status.Code(err)
+}
+
+func TestApp_TLS(t *testing.T) {
+	ca := newTestCA(t)
+	app := newTLSApp(t, writeTLSFiles(t, ca, nil))
+
+	if code := signUpCode(t, app, clientCreds(t, ca)); code != codes.OK {
+		t.Errorf("SignUp() over TLS code = %v, want OK", code)
+	}
+	if code := signUpCode(t, app, insecure.NewCredentials()); code != codes.Unavailable {
+		t.Errorf("SignUp() over plaintext code = %v, want Unavailable", code)
+	}
+	if code := signUpCode(t, app, clientCreds(t, newTestCA(t))); code != codes.Unavailable {
+		t.Errorf("SignUp() with untrusted server certificate code = %v, want Unavailable", code)
+	}
+}
+
+func TestApp_MutualTLS(t *testing.T) {
+	ca, clients := newTestCA(t), newTestCA(t)
+	app := newTLSApp(t, writeTLSFiles(t, ca, clients))
+
+	tests := []struct {
+		name  string
+		certs []tls.Certificate
+		want  codes.Code
+	}{
+		{"internal service", []tls.Certificate{clientCert(t, clients)}, codes.OK},
+		{"no client certificate", nil, codes.Unavailable},
+		{"certificate of other CA", []tls.Certificate{clientCert(t, newTestCA(t))}, codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := signUpCode(t, app, clientCreds(t, ca, tt.certs...)); code != tt.want {
+				t.Errorf("SignUp() code = %v, want %v", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCA(t)
+	files := writeTLSFiles(t, ca, ca)
+	r, err := newCertReloader(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.interval = 0
+
+	leaf := func() *x509.Certificate {
+		c := r.config()
+		cert, err := x509.ParseCertificate(c.Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	// Modification time of rotated files, past the coarse file system clock
+	touch := func(at time.Time) {
+		for _, path := range []string{files.CertFile, files.KeyFile} {
+			if err := os.Chtimes(path, at, at); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	first := leaf()
+
+	// Unchanged files are not reloaded
+	if got := leaf(); got.SerialNumber.Cmp(first.SerialNumber) != 0 {
+		t.Errorf("serial = %v without rotation, want %v", got.SerialNumber, first.SerialNumber)
+	}
+
+	cert, key := ca.issue(t, "localhost", x509.ExtKeyUsageServerAuth)
+	writeFile(t, files.CertFile, cert)
+	writeFile(t, files.KeyFile, key)
+	touch(time.Now().Add(time.Minute))
+	second := leaf()
+	if second.SerialNumber.Cmp(first.SerialNumber) == 0 {
+		t.Error("certificate not reloaded after rotation")
+	}
+
+	// Broken rotation keeps serving the last good certificate
+	writeFile(t, files.CertFile, []byte("garbage"))
+	touch(time.Now().Add(2 * time.Minute))
+	if got := leaf(); got.SerialNumber.Cmp(second.SerialNumber) != 0 {
+		t.Errorf("serial = %v after failed reload, want %v", got.SerialNumber, second.SerialNumber)
+	}
+	if r.config().ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Error("client certificates not required with client CA")
+	}
+}
@@ -0,0 +1,92 @@
+// nolint
+package server
+
+import (
+	"context"
+	"testing"
+
+	pb "example-grpc-auth/api"
+	"example-grpc-auth/config"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// Record spans of global provider until the test ends
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func TestApp_Tracing(t *testing.T) {
+	rec := recordSpans(t)
+	client := startApp(t)
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "traceparent", "00-"+traceID+"-"+spanID+"-01")
+	if _, err := client.SignUp(ctx, &pb.SignUpRequest{Username: "traced", Password: "pwd"}); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range rec.Ended() {
+		if s.SpanContext().TraceID().String() == traceID {
+			spans[s.Name()] = s
+		}
+	}
+	// Each span is a child of the previous one
+	parent := trace.SpanContext{}
+	for _, name := range []string{"api.AuthService/SignUp", "AuthServer.SignUp", "bcrypt.GenerateFromPassword"} {
+		s, ok := spans[name]
+		if !ok {
+			t.Fatalf("span %q not recorded in caller's trace, got %v", name, spans)
+		}
+		if name == "api.AuthService/SignUp" {
+			if got := s.Parent().SpanID().String(); got != spanID {
+				t.Errorf("server span parent = %s, want caller's %s", got, spanID)
+			}
+		} else if s.Parent().SpanID() != parent.SpanID() {
+			t.Errorf("span %q parent = %s, want %s", name, s.Parent().SpanID(), parent.SpanID())
+		}
+		parent = s.SpanContext()
+	}
+}
+
+func TestInitTracing(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	tests := []struct {
+		exporter string
+		wantTP   bool
+		wantErr  bool
+	}{
+		{"", false, false},
+		{"stdout", true, false},
+		{"otlp", true, false},
+		{"zipkin", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.exporter, func(t *testing.T) {
+			tp, err := initTracing(context.Background(), config.Tracing{Exporter: tt.exporter, Endpoint: "localhost:4317", Insecure: true})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("initTracing() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (tp != nil) != tt.wantTP {
+				t.Fatalf("initTracing() provider = %v, want provider %v", tp, tt.wantTP)
+			}
+			if tp != nil {
+				tp.Shutdown(context.Background())
+			}
+		})
+	}
+}